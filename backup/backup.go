@@ -0,0 +1,301 @@
+// Package backup writes and reads encrypted single-file vault archives.
+//
+// An archive starts with a random archive key wrapped under the backup
+// passphrase (a securecrypto envelope, so the KDF and its costs travel with
+// the file), followed by length-prefixed chunks sealed with that key. Each
+// chunk is bound to the file header, its index and whether it is the last
+// one, so chunks cannot be reordered, dropped or cut off unnoticed. Chunks
+// are cut at a fixed size, not between records: the reader joins them
+// before parsing, so one large entry may span several.
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/mbbgs/rook/models"
+	"github.com/mbbgs/rook/securecrypto"
+	"github.com/mbbgs/rook/types"
+)
+
+const (
+	magic     = "RKBK"
+	version   = 1
+	chunkSize = 64 << 10
+	maxChunk  = chunkSize + 1<<10 // plaintext limit plus envelope overhead
+)
+
+var ErrInvalid = errors.New("not a rook backup, or the archive is damaged")
+
+// Entry is one vault entry as stored in an archive. Deleted is when a
+// trashed entry was moved to the trash, and zero for live ones.
+type Entry struct {
+	Label   types.Label `json:"label"`
+	Data    types.Data  `json:"data"`
+	Deleted time.Time   `json:"deleted,omitzero"`
+}
+
+// Archive is the fully validated content of a backup file. A vault backup
+// has User, Entries and Trash; a sealed export has Name and Content
+// instead.
+type Archive struct {
+	Created time.Time
+	User    *models.User
+	Entries []Entry
+	Trash   []Entry
+	Name    string
+	Content []byte
+}
+
+// record is one JSON line of the archive stream.
+type record struct {
+	Kind    string       `json:"kind"`
+	Created time.Time    `json:"created,omitempty"`
+	User    *models.User `json:"user,omitempty"`
+	Entry   *Entry       `json:"entry,omitempty"`
+	Name    string       `json:"name,omitempty"`
+	Data    []byte       `json:"data,omitempty"`
+}
+
+// Writer streams a vault into an archive. Call Close to seal the last chunk;
+// an archive without it does not restore.
+type Writer struct {
+	w      io.Writer
+	key    []byte
+	header []byte
+	buf    bytes.Buffer
+	index  uint64
+}
+
+// NewWriter writes the archive header to w and records user as the first
+// item of the stream.
+func NewWriter(w io.Writer, passphrase []byte, user *models.User) (*Writer, error) {
+	return newWriter(w, passphrase, record{Kind: "vault", Created: time.Now().UTC(), User: user})
+}
+
+// WriteFile seals content, such as a plaintext export, as an archive named
+// name.
+func WriteFile(w io.Writer, passphrase []byte, name string, content []byte) error {
+	bw, err := newWriter(w, passphrase, record{Kind: "file", Created: time.Now().UTC(), Name: name})
+	if err != nil {
+		return err
+	}
+	for len(content) > 0 {
+		n := min(len(content), chunkSize/2)
+		if err := bw.write(record{Kind: "data", Data: content[:n]}); err != nil {
+			return err
+		}
+		content = content[n:]
+	}
+	return bw.Close()
+}
+
+func newWriter(w io.Writer, passphrase []byte, first record) (*Writer, error) {
+	key, err := securecrypto.NewDataKey()
+	if err != nil {
+		return nil, err
+	}
+	params, err := securecrypto.DefaultKDF()
+	if err != nil {
+		return nil, err
+	}
+	wrapped, err := securecrypto.WrapKey(key, passphrase, params, []byte(magic))
+	if err != nil {
+		return nil, err
+	}
+
+	header := []byte(magic)
+	header = append(header, version)
+	header = binary.BigEndian.AppendUint32(header, uint32(len(wrapped)))
+	header = append(header, wrapped...)
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+
+	bw := &Writer{w: w, key: key, header: header}
+	return bw, bw.write(first)
+}
+
+// Add appends one entry to the archive.
+func (bw *Writer) Add(label types.Label, data types.Data) error {
+	return bw.write(record{Kind: "entry", Entry: &Entry{Label: label, Data: data}})
+}
+
+// AddTrashed appends one entry from the trash, deleted at deleted.
+func (bw *Writer) AddTrashed(label types.Label, data types.Data, deleted time.Time) error {
+	if deleted.IsZero() {
+		return errors.New("trashed entry has no deletion time")
+	}
+	return bw.write(record{Kind: "trash", Entry: &Entry{Label: label, Data: data, Deleted: deleted}})
+}
+
+// Close seals the final chunk. It does not close the underlying writer.
+func (bw *Writer) Close() error {
+	if err := bw.write(record{Kind: "end"}); err != nil {
+		return err
+	}
+	err := bw.flush(true)
+	for i := range bw.key {
+		bw.key[i] = 0
+	}
+	return err
+}
+
+func (bw *Writer) write(r record) error {
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	bw.buf.Write(line)
+	bw.buf.WriteByte('\n')
+	for bw.buf.Len() >= chunkSize {
+		if err := bw.flush(false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// flush seals up to chunkSize buffered bytes as the next chunk.
+func (bw *Writer) flush(final bool) error {
+	chunk := bw.buf.Next(min(bw.buf.Len(), chunkSize))
+	sealed, err := securecrypto.Encrypt(chunk, bw.key, securecrypto.KDFParams{}, chunkAAD(bw.header, bw.index, final))
+	if err != nil {
+		return err
+	}
+	bw.index++
+
+	size := binary.BigEndian.AppendUint32(nil, uint32(len(sealed)))
+	if _, err := bw.w.Write(size); err != nil {
+		return err
+	}
+	_, err = bw.w.Write(sealed)
+	return err
+}
+
+// IsArchive reports whether head, the first bytes of a file, look like an
+// archive.
+func IsArchive(head []byte) bool {
+	return bytes.HasPrefix(head, []byte(magic))
+}
+
+func chunkAAD(header []byte, index uint64, final bool) []byte {
+	aad := append([]byte(nil), header...)
+	aad = binary.BigEndian.AppendUint64(aad, index)
+	if final {
+		return append(aad, 1)
+	}
+	return append(aad, 0)
+}
+
+// Read decrypts and validates a whole archive. Nothing is returned unless
+// every chunk authenticates, the stream ends where the writer closed it and
+// every record parses.
+func Read(r io.Reader, passphrase []byte) (*Archive, error) {
+	br := bufio.NewReader(r)
+
+	head := make([]byte, len(magic)+1+4)
+	if _, err := io.ReadFull(br, head); err != nil || string(head[:len(magic)]) != magic {
+		return nil, ErrInvalid
+	}
+	if head[len(magic)] != version {
+		return nil, fmt.Errorf("unsupported backup version %d", head[len(magic)])
+	}
+	n := binary.BigEndian.Uint32(head[len(magic)+1:])
+	if n > 4<<10 {
+		return nil, ErrInvalid
+	}
+	wrapped := make([]byte, n)
+	if _, err := io.ReadFull(br, wrapped); err != nil {
+		return nil, ErrInvalid
+	}
+	header := append(head, wrapped...)
+
+	key, _, err := securecrypto.UnwrapKey(wrapped, passphrase, []byte(magic))
+	if err != nil {
+		return nil, errors.New("wrong backup passphrase or damaged archive")
+	}
+	defer func() {
+		for i := range key {
+			key[i] = 0
+		}
+	}()
+
+	var stream bytes.Buffer
+	for index := uint64(0); ; index++ {
+		size := make([]byte, 4)
+		if _, err := io.ReadFull(br, size); err != nil {
+			return nil, errors.New("archive is truncated")
+		}
+		n := binary.BigEndian.Uint32(size)
+		if n > maxChunk {
+			return nil, ErrInvalid
+		}
+		sealed := make([]byte, n)
+		if _, err := io.ReadFull(br, sealed); err != nil {
+			return nil, errors.New("archive is truncated")
+		}
+
+		plain, err := securecrypto.Decrypt(sealed, key, chunkAAD(header, index, false))
+		if err == nil {
+			stream.Write(plain)
+			continue
+		}
+		plain, err = securecrypto.Decrypt(sealed, key, chunkAAD(header, index, true))
+		if err != nil {
+			return nil, fmt.Errorf("chunk %d failed authentication", index)
+		}
+		stream.Write(plain)
+		break
+	}
+	if _, err := br.ReadByte(); err != io.EOF {
+		return nil, errors.New("unexpected data after the final chunk")
+	}
+
+	return parse(&stream)
+}
+
+func parse(stream io.Reader) (*Archive, error) {
+	var archive Archive
+	var kind string
+	var sawEnd bool
+	dec := json.NewDecoder(stream)
+	for dec.More() {
+		var r record
+		if err := dec.Decode(&r); err != nil {
+			return nil, err
+		}
+		switch {
+		case sawEnd:
+			return nil, errors.New("records after end of archive")
+		case kind == "" && r.Kind == "vault" && r.User != nil:
+			kind = r.Kind
+			archive.Created = r.Created
+			archive.User = r.User
+		case kind == "" && r.Kind == "file" && r.Name != "":
+			kind = r.Kind
+			archive.Created = r.Created
+			archive.Name = r.Name
+		case kind == "vault" && r.Kind == "entry" && r.Entry != nil && r.Entry.Deleted.IsZero():
+			archive.Entries = append(archive.Entries, *r.Entry)
+		case kind == "vault" && r.Kind == "trash" && r.Entry != nil && !r.Entry.Deleted.IsZero():
+			archive.Trash = append(archive.Trash, *r.Entry)
+		case kind == "file" && r.Kind == "data":
+			archive.Content = append(archive.Content, r.Data...)
+		case kind != "" && r.Kind == "end":
+			sawEnd = true
+		default:
+			return nil, fmt.Errorf("unexpected %q record in archive", r.Kind)
+		}
+	}
+	if !sawEnd {
+		return nil, errors.New("archive has no end record")
+	}
+	return &archive, nil
+}
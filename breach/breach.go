@@ -0,0 +1,317 @@
+// Package breach looks passwords up in a locally downloaded copy of the
+// Have I Been Pwned password list, without touching the network.
+//
+// Two layouts are understood: the single text file ordered by hash, with
+// lines of "SHA1:COUNT", and a directory of range files as written by the
+// PwnedPasswordsDownloader, one "ABCDE.txt" per 5-character hash prefix
+// holding "SUFFIX:COUNT" lines.
+package breach
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/mbbgs/rook/consts"
+	"github.com/mbbgs/rook/utils"
+)
+
+const hashLen = 40
+
+// ErrUnsorted means a single-file dataset is not ordered by hash, so it
+// cannot be searched.
+var ErrUnsorted = errors.New("breach: file is not ordered by hash; download the hash-ordered list")
+
+// Dataset is an opened local copy of the password list.
+type Dataset struct {
+	path string
+	file *os.File // single sorted file; nil for a range directory
+	size int64
+}
+
+// Open opens the dataset at path, which is either the sorted text file or a
+// directory of range files.
+func Open(path string) (*Dataset, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			if prefix := strings.TrimSuffix(e.Name(), ".txt"); len(prefix) == 5 && isHex(prefix) {
+				return &Dataset{path: path}, nil
+			}
+		}
+		return nil, fmt.Errorf("breach: %s holds no range files", path)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	d := &Dataset{path: path, file: f, size: info.Size()}
+	if err := d.checkSorted(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return d, nil
+}
+
+// Path is where the dataset was opened from.
+func (d *Dataset) Path() string {
+	return d.path
+}
+
+// Close releases the dataset.
+func (d *Dataset) Close() error {
+	if d.file == nil {
+		return nil
+	}
+	return d.file.Close()
+}
+
+// Count returns how many times password appears in the breach data; zero
+// means it was not found.
+func (d *Dataset) Count(password []byte) (int, error) {
+	sum := sha1.Sum(password)
+	return d.CountHash(strings.ToUpper(hex.EncodeToString(sum[:])))
+}
+
+// CountHash is Count for an uppercase hex SHA-1.
+func (d *Dataset) CountHash(hash string) (int, error) {
+	if len(hash) != hashLen {
+		return 0, errors.New("breach: malformed hash")
+	}
+	if d.file == nil {
+		return d.countInRange(hash)
+	}
+	return d.countInFile(hash)
+}
+
+// countInRange scans the one small range file that can hold hash.
+func (d *Dataset) countInRange(hash string) (int, error) {
+	path, err := d.rangeFile(hash[:5])
+	if err != nil {
+		return 0, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	suffix := hash[5:]
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		s, count, ok := strings.Cut(line, ":")
+		if ok && strings.EqualFold(s, suffix) {
+			return strconv.Atoi(count)
+		}
+	}
+	return 0, sc.Err()
+}
+
+func (d *Dataset) rangeFile(prefix string) (string, error) {
+	for _, name := range []string{prefix + ".txt", prefix, strings.ToLower(prefix) + ".txt"} {
+		path := filepath.Join(d.path, name)
+		if utils.FileExists(path) {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("range file %s not found", prefix)
+}
+
+// scanWindow is how close the binary search gets before reading lines in
+// order.
+const scanWindow = 4096
+
+// countInFile binary searches the sorted file by byte offset. lineAt(p)
+// reads the first line starting at or after p; its hash never decreases as
+// p grows, so the target line, if present, starts in [lo, hi).
+func (d *Dataset) countInFile(hash string) (int, error) {
+	lo, hi := int64(0), d.size
+	for hi-lo > scanWindow {
+		mid := lo + (hi-lo)/2
+		line, _, err := d.lineAt(mid)
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+		if line == nil || compareHash(line, hash) > 0 {
+			hi = mid
+		} else {
+			lo = mid
+		}
+	}
+
+	_, start, err := d.lineAt(lo)
+	if err == io.EOF {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	r := bufio.NewReader(io.NewSectionReader(d.file, start, d.size-start))
+	for {
+		line, err := r.ReadSlice('\n')
+		line = bytes.TrimSpace(line)
+		if len(line) >= hashLen {
+			switch c := compareHash(line, hash); {
+			case c == 0:
+				return parseCount(line)
+			case c > 0:
+				return 0, nil
+			}
+		}
+		if err == io.EOF {
+			return 0, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+// maxLine bounds one "HASH:COUNT" line.
+const maxLine = 128
+
+// lineAt returns the first complete line starting at or after p, and where
+// it starts.
+func (d *Dataset) lineAt(p int64) ([]byte, int64, error) {
+	start := p
+	if p > 0 {
+		// Back up one byte so a line starting exactly at p is not skipped.
+		start = p - 1
+	}
+	buf := make([]byte, 2*maxLine)
+	n, err := d.file.ReadAt(buf, start)
+	if err != nil && err != io.EOF {
+		return nil, 0, err
+	}
+	buf = buf[:n]
+	if p > 0 {
+		nl := bytes.IndexByte(buf, '\n')
+		if nl < 0 {
+			return nil, 0, io.EOF
+		}
+		start += int64(nl + 1)
+		buf = buf[nl+1:]
+	}
+	if len(buf) == 0 {
+		return nil, 0, io.EOF
+	}
+	if nl := bytes.IndexByte(buf, '\n'); nl >= 0 {
+		buf = buf[:nl]
+	}
+	return bytes.TrimSpace(buf), start, nil
+}
+
+// checkSorted compares the first lines, which is enough to tell the
+// hash-ordered list from the count-ordered one.
+func (d *Dataset) checkSorted() error {
+	r := bufio.NewReader(io.NewSectionReader(d.file, 0, min(d.size, 64*maxLine)))
+	var prev []byte
+	for i := 0; i < 32; i++ {
+		line, err := r.ReadSlice('\n')
+		line = bytes.TrimSpace(line)
+		if len(line) > 0 {
+			if len(line) <= hashLen || line[hashLen] != ':' {
+				return fmt.Errorf("breach: %s is not a HASH:COUNT list", d.path)
+			}
+			if prev != nil && bytes.Compare(bytes.ToUpper(prev[:hashLen]), bytes.ToUpper(line[:hashLen])) > 0 {
+				return ErrUnsorted
+			}
+			prev = append(prev[:0], line...)
+		}
+		if err != nil {
+			break
+		}
+	}
+	if prev == nil {
+		return fmt.Errorf("breach: %s is empty", d.path)
+	}
+	return nil
+}
+
+func isHex(s string) bool {
+	_, err := hex.DecodeString(s + "0")
+	return err == nil
+}
+
+func compareHash(line []byte, hash string) int {
+	if len(line) < hashLen {
+		return -1
+	}
+	return bytes.Compare(bytes.ToUpper(line[:hashLen]), []byte(hash))
+}
+
+func parseCount(line []byte) (int, error) {
+	_, count, ok := bytes.Cut(line, []byte(":"))
+	if !ok {
+		return 1, nil
+	}
+	return strconv.Atoi(string(bytes.TrimSpace(count)))
+}
+
+// configPath is where the dataset location is remembered.
+func configPath() (string, error) {
+	dir, err := utils.GetSessionDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, consts.HIBP_PATH), nil
+}
+
+// SavePath checks that path opens as a dataset and remembers it for later
+// runs. "none" forgets the saved location.
+func SavePath(path string) error {
+	config, err := configPath()
+	if err != nil {
+		return err
+	}
+	if path == "none" {
+		if err := os.Remove(config); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	d, err := Open(abs)
+	if err != nil {
+		return err
+	}
+	d.Close()
+	return os.WriteFile(config, []byte(abs+"\n"), 0600)
+}
+
+// OpenConfigured opens the dataset saved with SavePath. It returns nil and
+// no error when none is configured.
+func OpenConfigured() (*Dataset, error) {
+	config, err := configPath()
+	if err != nil {
+		return nil, err
+	}
+	raw, err := os.ReadFile(config)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return Open(strings.TrimSpace(string(raw)))
+}
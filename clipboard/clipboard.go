@@ -0,0 +1,194 @@
+// Package clipboard puts secrets on the system clipboard and takes them off
+// again. Local sessions use wl-copy, xclip or xsel; remote ones, and
+// terminals without those tools, use the OSC 52 escape, which asks the
+// terminal emulator itself to set its clipboard and so works over SSH and
+// inside tmux or screen.
+package clipboard
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// DefaultClearAfter is how long copied text stays on the clipboard unless
+// the user chose otherwise.
+const DefaultClearAfter = 30 * time.Second
+
+// ErrUnavailable means there is neither a clipboard tool nor a terminal to
+// send OSC 52 to.
+var ErrUnavailable = errors.New("no clipboard: install wl-copy, xclip or xsel, or run rook in a terminal")
+
+// tool is a clipboard command line tool. Text is passed on stdin, never as
+// an argument, so it does not show in the process list.
+type tool struct {
+	name    string
+	display string // environment variable naming the display it needs
+	copy    []string
+	paste   []string
+	clear   []string // nil means copy nothing
+}
+
+var tools = []tool{
+	{"wl-copy", "WAYLAND_DISPLAY", []string{"wl-copy"}, []string{"wl-paste", "--no-newline"}, []string{"wl-copy", "--clear"}},
+	{"xclip", "DISPLAY", []string{"xclip", "-selection", "clipboard"}, []string{"xclip", "-selection", "clipboard", "-o"}, nil},
+	{"xsel", "DISPLAY", []string{"xsel", "--clipboard", "--input"}, []string{"xsel", "--clipboard", "--output"}, []string{"xsel", "--clipboard", "--delete"}},
+}
+
+// Clipboard copies to the clipboard found by Open and clears it on a timer.
+type Clipboard struct {
+	tool *tool     // nil when copying with OSC 52
+	term io.Writer // where OSC 52 escapes are written
+
+	mu    sync.Mutex
+	held  string // what was last copied, until cleared
+	timer *time.Timer
+	gen   int // counts copies, so a late timer leaves newer text alone
+}
+
+// Open picks how to reach the clipboard. Over SSH the tools would set the
+// clipboard of the remote display, if any, so OSC 52 is used there; out is
+// the terminal it is written to.
+func Open(out *os.File) (*Clipboard, error) {
+	remote := os.Getenv("SSH_TTY") != "" || os.Getenv("SSH_CONNECTION") != ""
+	if !remote {
+		for i := range tools {
+			t := &tools[i]
+			if os.Getenv(t.display) == "" {
+				continue
+			}
+			if _, err := exec.LookPath(t.copy[0]); err == nil {
+				return &Clipboard{tool: t}, nil
+			}
+		}
+	}
+	if !term.IsTerminal(int(out.Fd())) {
+		return nil, ErrUnavailable
+	}
+	return &Clipboard{term: out}, nil
+}
+
+// Method names how text is copied: a tool, or "OSC 52".
+func (c *Clipboard) Method() string {
+	if c.tool != nil {
+		return c.tool.name
+	}
+	return "OSC 52"
+}
+
+// Copy puts text on the clipboard and, if clearAfter is positive, clears
+// it again once that time has passed, unless something else was copied
+// over it by then.
+func (c *Clipboard) Copy(text string, clearAfter time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+	if err := c.set(text); err != nil {
+		return err
+	}
+	c.held = text
+	c.gen++
+	if gen := c.gen; clearAfter > 0 {
+		c.timer = time.AfterFunc(clearAfter, func() { c.expire(gen) })
+	}
+	return nil
+}
+
+func (c *Clipboard) expire(gen int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if gen == c.gen {
+		_ = c.clear()
+	}
+}
+
+// Pending reports whether copied text is waiting to be cleared.
+func (c *Clipboard) Pending() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.held != ""
+}
+
+// Clear empties the clipboard if it still holds what was copied. A
+// terminal cannot be asked what its clipboard holds, so with OSC 52 it is
+// always emptied.
+func (c *Clipboard) Clear() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.clear()
+}
+
+func (c *Clipboard) clear() error {
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+	if c.held == "" {
+		return nil
+	}
+	held := c.held
+	c.held = ""
+	if c.tool != nil {
+		current, err := exec.Command(c.tool.paste[0], c.tool.paste[1:]...).Output()
+		if err == nil && string(current) != held {
+			return nil
+		}
+		if c.tool.clear != nil {
+			return run(c.tool.clear, "")
+		}
+	}
+	return c.set("")
+}
+
+func (c *Clipboard) set(text string) error {
+	if c.tool != nil {
+		if err := run(c.tool.copy, text); err != nil {
+			return fmt.Errorf("%s: %w", c.tool.name, err)
+		}
+		return nil
+	}
+	_, err := io.WriteString(c.term, osc52(text))
+	return err
+}
+
+// run starts argv with text on stdin. Output is not captured: xclip and
+// wl-copy leave a child behind to serve the selection, which would hold a
+// pipe open.
+func run(argv []string, text string) error {
+	cmd := exec.Command(argv[0], argv[1:]...)
+	cmd.Stdin = strings.NewReader(text)
+	return cmd.Run()
+}
+
+// osc52 is the escape that sets the terminal's clipboard to text; empty
+// text clears it. Inside tmux and screen it is wrapped so the multiplexer
+// passes it on to the terminal (tmux needs allow-passthrough).
+func osc52(text string) string {
+	seq := "\033]52;c;" + base64.StdEncoding.EncodeToString([]byte(text)) + "\a"
+	switch {
+	case os.Getenv("TMUX") != "":
+		return "\033Ptmux;" + strings.ReplaceAll(seq, "\033", "\033\033") + "\033\\"
+	case strings.HasPrefix(os.Getenv("TERM"), "screen"):
+		// screen cuts escapes at 768 bytes, so long text goes in chunks.
+		var b bytes.Buffer
+		for len(seq) > 0 {
+			n := min(len(seq), 512)
+			b.WriteString("\033P" + seq[:n] + "\033\\")
+			seq = seq[n:]
+		}
+		return b.String()
+	}
+	return seq
+}
@@ -16,11 +16,19 @@ const (
   	USER_LOGGED_IN    = "user:logged in"
   	USER_REGISTRATION = "user: registration "
   	RESET_PASSWORD    =  "user:reset password"
+  	RECOVER_VAULT     = "user:recover vault"
+  	REKEY_VAULT       = "user:rekey vault"
+  	BACKUP_VAULT      = "user:backup vault"
+  	RESTORE_VAULT     = "user:restore vault"
+  	SEARCH_VAULT      = "user:search vault"
   	F_USER_LOGOUT     = "user:f_logout"
   	SECRET_ROOK       = ".secret.rook"
   	STORE_FILE_PATH   = "storook"
   	ATTEMPTS_PATH     = ".attempts.rook"
+  	ANCHOR_PATH       = ".anchor.rook"
   	ROOK_LOG          = ".log.rook"
+  	HIBP_PATH         = ".hibp.rook"
+  	SCHEMA_BACKUP     = ".schema.rook"
   
   	SALT_SIZE         = 16
   	MAX_ATTEMPTS      = 06
@@ -32,7 +40,7 @@ const (
 1. No Cloud Storage – All data is local.
 2. Zero Trust – No implicit trust.
 3. 5 Failed Attempts – Auto-wipe triggered.
-4. No Recovery – Use at your own risk.
+4. Master Key Only – Lose it and your password, lose the vault.
 5. Immediate Termination – No support, no mercy.
 `
 )
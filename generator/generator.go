@@ -0,0 +1,369 @@
+// Package generator creates random passwords and passphrases and reports
+// how much entropy they carry.
+package generator
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+)
+
+// Character classes for random passwords.
+const (
+	LowerChars  = "abcdefghijklmnopqrstuvwxyz"
+	UpperChars  = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	DigitChars  = "0123456789"
+	SymbolChars = "!#$%&()*+,-./:;<=>?@[]^_{}~"
+	// Ambiguous characters are easy to misread; the first five are the ones
+	// utils.ValidatePassword rejects.
+	Ambiguous = "O0l1I|`'\""
+)
+
+type Mode int
+
+const (
+	Random        Mode = iota // characters drawn from the enabled classes
+	Pronounceable             // alternating consonants and vowels
+	Passphrase                // words from the EFF large wordlist
+)
+
+// Capitalization applies to passphrase words.
+type Capitalization string
+
+const (
+	CapNone   Capitalization = "none"
+	CapFirst  Capitalization = "first"  // Every Word Capitalized
+	CapAll    Capitalization = "all"    // EVERY WORD
+	CapRandom Capitalization = "random" // each word capitalized or not, one bit each
+)
+
+// Options describe what to generate. Length and the class settings apply
+// to random and pronounceable passwords; Words, Separator and Capitalize to
+// passphrases.
+type Options struct {
+	Mode   Mode
+	Length int
+
+	Lower, Upper, Digits, Symbols             bool
+	MinLower, MinUpper, MinDigits, MinSymbols int
+	Exclude                                   string
+	AllowAmbiguous                            bool
+
+	Words      int
+	Separator  string
+	Capitalize Capitalization
+}
+
+// Result is a generated secret and its entropy in bits, assuming the
+// attacker knows the options it was generated with.
+type Result struct {
+	Password string
+	Entropy  float64
+}
+
+// Defaults returns options for a 20-character password with every class
+// and at least one character of each.
+func Defaults() Options {
+	return Options{
+		Mode:   Random,
+		Length: 20,
+		Lower:  true, Upper: true, Digits: true, Symbols: true,
+		MinLower: 1, MinUpper: 1, MinDigits: 1, MinSymbols: 1,
+		Words:      6,
+		Separator:  "-",
+		Capitalize: CapNone,
+	}
+}
+
+// Generate creates a secret according to o.
+func Generate(o Options) (Result, error) {
+	switch o.Mode {
+	case Random:
+		return randomPassword(o)
+	case Pronounceable:
+		return pronounceable(o)
+	case Passphrase:
+		return passphrase(o)
+	}
+	return Result{}, errors.New("unknown generator mode")
+}
+
+type class struct {
+	chars string
+	min   int
+}
+
+// classes returns the enabled character classes with exclusions applied.
+func (o Options) classes() ([]class, error) {
+	exclude := o.Exclude
+	if !o.AllowAmbiguous {
+		exclude += Ambiguous
+	}
+	var out []class
+	for _, c := range []struct {
+		on    bool
+		chars string
+		min   int
+		name  string
+	}{
+		{o.Lower, LowerChars, o.MinLower, "lowercase"},
+		{o.Upper, UpperChars, o.MinUpper, "uppercase"},
+		{o.Digits, DigitChars, o.MinDigits, "digit"},
+		{o.Symbols, SymbolChars, o.MinSymbols, "symbol"},
+	} {
+		if !c.on {
+			continue
+		}
+		chars := strings.Map(func(r rune) rune {
+			if strings.ContainsRune(exclude, r) {
+				return -1
+			}
+			return r
+		}, c.chars)
+		if chars == "" {
+			if c.min > 0 {
+				return nil, fmt.Errorf("every %s character is excluded", c.name)
+			}
+			continue
+		}
+		out = append(out, class{chars, max(c.min, 0)})
+	}
+	if len(out) == 0 {
+		return nil, errors.New("no character classes enabled")
+	}
+	return out, nil
+}
+
+// randomPassword draws every character from the union of the classes and
+// retries until the minimums hold. Rejection keeps the result uniform over
+// all passwords that satisfy the policy, so the entropy is exact.
+func randomPassword(o Options) (Result, error) {
+	if o.Length < 4 || o.Length > 128 {
+		return Result{}, errors.New("length must be between 4 and 128")
+	}
+	classes, err := o.classes()
+	if err != nil {
+		return Result{}, err
+	}
+	var alphabet string
+	required := 0
+	for _, c := range classes {
+		alphabet += c.chars
+		required += c.min
+	}
+	if required > o.Length {
+		return Result{}, fmt.Errorf("minimum counts add up to %d, more than the length %d", required, o.Length)
+	}
+
+	buf := make([]byte, o.Length)
+	for attempt := 0; attempt < 10000; attempt++ {
+		for i := range buf {
+			n, err := randInt(len(alphabet))
+			if err != nil {
+				return Result{}, err
+			}
+			buf[i] = alphabet[n]
+		}
+		if meetsMinimums(buf, classes) {
+			return Result{Password: string(buf), Entropy: policyEntropy(o.Length, classes)}, nil
+		}
+	}
+	return Result{}, errors.New("minimum counts are too strict for this length")
+}
+
+func meetsMinimums(pw []byte, classes []class) bool {
+	for _, c := range classes {
+		n := 0
+		for _, b := range pw {
+			if strings.IndexByte(c.chars, b) >= 0 {
+				n++
+			}
+		}
+		if n < c.min {
+			return false
+		}
+	}
+	return true
+}
+
+// policyEntropy is log2 of the number of length-character strings over the
+// classes that meet every minimum, counted with exponential generating
+// functions: g[n] sums s^k/k! over the ways to take k of n from a class.
+func policyEntropy(length int, classes []class) float64 {
+	g := make([]float64, length+1)
+	g[0] = 1
+	for _, c := range classes {
+		next := make([]float64, length+1)
+		s := float64(len(c.chars))
+		for n := 0; n <= length; n++ {
+			term := 1.0 // s^k / k!
+			for k := 0; k <= n; k++ {
+				if k > 0 {
+					term *= s / float64(k)
+				}
+				if k >= c.min {
+					next[n] += g[n-k] * term
+				}
+			}
+		}
+		g = next
+	}
+	lgamma, _ := math.Lgamma(float64(length + 1))
+	return (math.Log(g[length]) + lgamma) / math.Ln2
+}
+
+// Letters for pronounceable passwords. Consonants that read ambiguously
+// next to vowels (c, q, x) are left out.
+const (
+	consonants = "bdfghjklmnprstvwz"
+	vowels     = "aeiou"
+)
+
+// pronounceable alternates consonants and vowels, then appends the digits
+// and symbols the minimums ask for. With Upper set, the first consonants,
+// MinUpper of them and at least one, are capitals. The letters are
+// lowercase otherwise, so Lower cannot be turned off.
+func pronounceable(o Options) (Result, error) {
+	if o.Length < 4 || o.Length > 128 {
+		return Result{}, errors.New("length must be between 4 and 128")
+	}
+	exclude := o.Exclude
+	if !o.AllowAmbiguous {
+		exclude += Ambiguous
+	}
+	strip := func(s string) string {
+		return strings.Map(func(r rune) rune {
+			if strings.ContainsRune(exclude, r) {
+				return -1
+			}
+			return r
+		}, s)
+	}
+	cons, vows := strip(consonants), strip(vowels)
+	upperCons := strip(strings.ToUpper(consonants))
+	digits, symbols := strip(DigitChars), strip(SymbolChars)
+	if cons == "" || vows == "" {
+		return Result{}, errors.New("too many letters excluded")
+	}
+	if !o.Lower {
+		return Result{}, errors.New("pronounceable passwords are made of lowercase letters; lowercase cannot be turned off")
+	}
+	uppers := 0
+	if o.Upper {
+		uppers = max(o.MinUpper, 1)
+		if upperCons == "" {
+			return Result{}, errors.New("every upper-case consonant is excluded")
+		}
+	}
+
+	extras := 0
+	if o.Digits {
+		extras += o.MinDigits
+	}
+	if o.Symbols {
+		extras += o.MinSymbols
+	}
+	letters := o.Length - extras
+	if letters < 2 {
+		return Result{}, errors.New("length too short for the requested digits and symbols")
+	}
+	if syllables := (letters + 1) / 2; uppers > syllables {
+		return Result{}, fmt.Errorf("%d letters have room for at most %d capitals", letters, syllables)
+	}
+	if o.MinLower > letters-uppers {
+		return Result{}, fmt.Errorf("%d letters have room for at most %d lowercase with %d capitals", letters, letters-uppers, uppers)
+	}
+
+	var sb strings.Builder
+	entropy := 0.0
+	pick := func(set string) error {
+		n, err := randInt(len(set))
+		if err != nil {
+			return err
+		}
+		sb.WriteByte(set[n])
+		entropy += math.Log2(float64(len(set)))
+		return nil
+	}
+	for i := 0; i < letters; i++ {
+		set := cons
+		switch {
+		case i%2 == 1:
+			set = vows
+		case i/2 < uppers:
+			set = upperCons
+		}
+		if err := pick(set); err != nil {
+			return Result{}, err
+		}
+	}
+	for _, extra := range []struct {
+		on  bool
+		n   int
+		set string
+	}{{o.Digits, o.MinDigits, digits}, {o.Symbols, o.MinSymbols, symbols}} {
+		if !extra.on || extra.n <= 0 {
+			continue
+		}
+		if extra.set == "" {
+			return Result{}, errors.New("every digit or symbol is excluded")
+		}
+		for i := 0; i < extra.n; i++ {
+			if err := pick(extra.set); err != nil {
+				return Result{}, err
+			}
+		}
+	}
+
+	return Result{Password: sb.String(), Entropy: entropy}, nil
+}
+
+// passphrase picks Words words from the EFF large wordlist.
+func passphrase(o Options) (Result, error) {
+	if o.Words < 3 || o.Words > 20 {
+		return Result{}, errors.New("word count must be between 3 and 20")
+	}
+	list := wordlist()
+	words := make([]string, o.Words)
+	entropy := 0.0
+	for i := range words {
+		n, err := randInt(len(list))
+		if err != nil {
+			return Result{}, err
+		}
+		word := list[n]
+		entropy += math.Log2(float64(len(list)))
+
+		switch o.Capitalize {
+		case CapFirst:
+			word = strings.ToUpper(word[:1]) + word[1:]
+		case CapAll:
+			word = strings.ToUpper(word)
+		case CapRandom:
+			flip, err := randInt(2)
+			if err != nil {
+				return Result{}, err
+			}
+			if flip == 1 {
+				word = strings.ToUpper(word[:1]) + word[1:]
+			}
+			entropy++
+		case CapNone, "":
+		default:
+			return Result{}, fmt.Errorf("unknown capitalization %q", o.Capitalize)
+		}
+		words[i] = word
+	}
+	return Result{Password: strings.Join(words, o.Separator), Entropy: entropy}, nil
+}
+
+func randInt(n int) (int, error) {
+	v, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		return 0, err
+	}
+	return int(v.Int64()), nil
+}
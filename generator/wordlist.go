@@ -0,0 +1,30 @@
+package generator
+
+import (
+	_ "embed"
+	"strings"
+	"sync"
+)
+
+// eff_large_wordlist.txt is the EFF large wordlist for five-dice diceware:
+// 7776 lines of "dice<TAB>word".
+//
+//go:embed eff_large_wordlist.txt
+var effLarge string
+
+var wordlist = sync.OnceValue(func() []string {
+	lines := strings.Split(strings.TrimSpace(effLarge), "\n")
+	words := make([]string, 0, len(lines))
+	for _, line := range lines {
+		if _, word, ok := strings.Cut(line, "\t"); ok {
+			words = append(words, strings.TrimSpace(word))
+		}
+	}
+	return words
+})
+
+// Words returns the EFF large wordlist in dice order. The slice is shared;
+// callers must not modify it.
+func Words() []string {
+	return wordlist()
+}
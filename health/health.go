@@ -0,0 +1,265 @@
+// Package health audits a vault for passwords that need attention: reused,
+// nearly the same as another, weak, old, or saved without a site.
+package health
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mbbgs/rook/strength"
+	"github.com/mbbgs/rook/types"
+)
+
+// DefaultMaxAge is how long a password may go unchanged before it is
+// reported as due for rotation.
+const DefaultMaxAge = 180 * 24 * time.Hour
+
+// Options tune the audit.
+type Options struct {
+	MaxAge time.Duration // passwords older than this are stale
+	Now    time.Time     // reference time; zero means time.Now()
+
+	// Breached returns how often a password appears in known breaches. It
+	// is optional.
+	Breached func(password []byte) int
+}
+
+// Weak is an entry whose password scores below strength.Fair.
+type Weak struct {
+	Label  string
+	Result strength.Result
+}
+
+// Breached is an entry whose password appears in known breaches.
+type Breached struct {
+	Label string
+	Count int
+}
+
+// Pair is two entries whose passwords differ only slightly.
+type Pair struct {
+	A, B     string
+	Distance int // edits between the passwords, ignoring case
+}
+
+// Stale is an entry whose password is older than Options.MaxAge.
+type Stale struct {
+	Label   string
+	Changed time.Time
+	Age     time.Duration
+}
+
+// Report lists every issue found and an overall score from 0 to 100.
+type Report struct {
+	Entries    int
+	Breached   []Breached
+	Reused     [][]string // labels sharing one password, one group per password
+	Near       []Pair
+	Weak       []Weak
+	Stale      []Stale
+	Undated    []string // no record of when the password was set
+	MissingURL []string
+	Score      int
+}
+
+// Per-entry penalties, out of 100. An entry's penalty is capped at 100 and
+// the score is 100 minus the average penalty.
+const (
+	penaltyBreached   = 60
+	penaltyVeryWeak   = 50
+	penaltyWeak       = 35
+	penaltyReused     = 30
+	penaltyNear       = 20
+	penaltyStale      = 15
+	penaltyMissingURL = 5
+)
+
+// Check audits entries, keyed by label.
+func Check(entries map[string]types.Data, o Options) Report {
+	if o.MaxAge <= 0 {
+		o.MaxAge = DefaultMaxAge
+	}
+	if o.Now.IsZero() {
+		o.Now = time.Now()
+	}
+	// Only entries with a password are audited; notes, cards and the
+	// like have nothing to reuse or age.
+	labels := make([]string, 0, len(entries))
+	for label, data := range entries {
+		if data.EntryKind().HasPassword() {
+			labels = append(labels, label)
+		}
+	}
+	sort.Strings(labels)
+
+	r := Report{Entries: len(labels)}
+	penalty := make(map[string]int, len(labels))
+
+	byPassword := make(map[string][]string)
+	for _, label := range labels {
+		data := entries[label]
+		// Entries without a password share nothing; they show up as weak.
+		if len(data.Lpassword) > 0 {
+			byPassword[string(data.Lpassword)] = append(byPassword[string(data.Lpassword)], label)
+		}
+
+		if o.Breached != nil {
+			if n := o.Breached(data.Lpassword); n > 0 {
+				r.Breached = append(r.Breached, Breached{label, n})
+				penalty[label] += penaltyBreached
+			}
+		}
+
+		if res := strength.Estimate(string(data.Lpassword), label, data.Lname, data.Lurl); res.Score < strength.Fair {
+			r.Weak = append(r.Weak, Weak{label, res})
+			if res.Score == strength.VeryWeak {
+				penalty[label] += penaltyVeryWeak
+			} else {
+				penalty[label] += penaltyWeak
+			}
+		}
+
+		switch age := o.Now.Sub(data.Changed); {
+		case data.Changed.IsZero():
+			r.Undated = append(r.Undated, label)
+		case age > o.MaxAge:
+			r.Stale = append(r.Stale, Stale{label, data.Changed, age})
+			penalty[label] += penaltyStale
+		}
+
+		if url := strings.TrimSpace(data.Lurl); data.EntryKind() == types.KindLogin && (url == "" || url == "(Not Set)") {
+			r.MissingURL = append(r.MissingURL, label)
+			penalty[label] += penaltyMissingURL
+		}
+	}
+
+	for _, group := range byPassword {
+		if len(group) < 2 {
+			continue
+		}
+		r.Reused = append(r.Reused, group)
+		for _, label := range group {
+			penalty[label] += penaltyReused
+		}
+	}
+	sort.Slice(r.Reused, func(i, j int) bool { return r.Reused[i][0] < r.Reused[j][0] })
+
+	r.Near = nearDuplicates(labels, entries)
+	nearSeen := make(map[string]bool)
+	for _, p := range r.Near {
+		for _, label := range []string{p.A, p.B} {
+			if !nearSeen[label] {
+				nearSeen[label] = true
+				penalty[label] += penaltyNear
+			}
+		}
+	}
+
+	r.Score = 100
+	if len(labels) > 0 {
+		total := 0
+		for _, p := range penalty {
+			total += min(p, 100)
+		}
+		r.Score = 100 - (total+len(labels)/2)/len(labels)
+	}
+	return r
+}
+
+// Issues counts the entries flagged in each category.
+func (r Report) Issues() int {
+	n := len(r.Breached) + len(r.Near) + len(r.Weak) + len(r.Stale) + len(r.MissingURL)
+	for _, g := range r.Reused {
+		n += len(g)
+	}
+	return n
+}
+
+// Grade names the score.
+func (r Report) Grade() string {
+	switch {
+	case r.Score >= 90:
+		return "excellent"
+	case r.Score >= 75:
+		return "good"
+	case r.Score >= 50:
+		return "fair"
+	}
+	return "poor"
+}
+
+// minNearLength keeps short passwords, which are flagged as weak anyway,
+// from pairing up with everything.
+const minNearLength = 6
+
+// nearDuplicates pairs passwords that are not identical but differ by a
+// few edits, such as "Summer2023!" and "Summer2024!", or only by case.
+func nearDuplicates(labels []string, entries map[string]types.Data) []Pair {
+	type pw struct {
+		label string
+		lower []rune
+		raw   []byte
+	}
+	list := make([]pw, 0, len(labels))
+	for _, label := range labels {
+		raw := entries[label].Lpassword
+		lower := []rune(strings.ToLower(string(raw)))
+		if len(lower) >= minNearLength {
+			list = append(list, pw{label, lower, raw})
+		}
+	}
+
+	var out []Pair
+	for i := range list {
+		for j := i + 1; j < len(list); j++ {
+			a, b := list[i], list[j]
+			if bytes.Equal(a.raw, b.raw) {
+				continue // reused, reported separately
+			}
+			limit := max(1, max(len(a.lower), len(b.lower))/4)
+			if abs(len(a.lower)-len(b.lower)) > limit {
+				continue
+			}
+			if d := distance(a.lower, b.lower, limit); d <= limit {
+				out = append(out, Pair{a.label, b.label, d})
+			}
+		}
+	}
+	return out
+}
+
+// distance is the Levenshtein distance between a and b, or limit+1 once it
+// is known to exceed limit.
+func distance(a, b []rune, limit int) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		best := cur[0]
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			best = min(best, cur[j])
+		}
+		if best > limit {
+			return limit + 1
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -1,58 +1,48 @@
 package hooks
 
 import (
-	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
 
+	"github.com/mbbgs/rook/backup"
+	"github.com/mbbgs/rook/breach"
 	"github.com/mbbgs/rook/consts"
 	"github.com/mbbgs/rook/events"
 	"github.com/mbbgs/rook/models"
 	"github.com/mbbgs/rook/securecrypto"
 	"github.com/mbbgs/rook/store"
 	"github.com/mbbgs/rook/terms"
+	"github.com/mbbgs/rook/types"
 	"github.com/mbbgs/rook/utils"
+	"github.com/mbbgs/rook/views"
 )
 
 func UserRegistration(username, password, masterkey string, Event *events.Event) {
 	username, password, masterkey = sanitizeCreds(username, password, masterkey)
-	if !isValidCreds(username, password, masterkey) || !validatePassword(password) {
+	if !isValidCreds(username, password, masterkey) || !validatePassword(password) || !notBreached(password) {
 		return
 	}
 
-	salt, err := securecrypto.GenerateSalt()
+	dek, err := securecrypto.NewDataKey()
 	if err != nil {
 		utils.ErrorE(err)
 		return
 	}
 
-	msalt, err := securecrypto.GenerateSalt()
-	if err != nil {
+	newUser := models.NewUser(username, "", "")
+	if err := setPassword(newUser, dek, password); err != nil {
 		utils.ErrorE(err)
 		return
 	}
-
-	 hashedPassword, err := securecrypto.HashWithSalt(password, salt)
-	if err != nil {
+	if err := setMasterKey(newUser, dek, masterkey); err != nil {
 		utils.ErrorE(err)
 		return
 	}
 
-	hashedMaster, err := securecrypto.HashWithSalt(masterkey, msalt)
-	if err != nil {
-		utils.ErrorE(err)
-		return
-	}
-
-	newUser := models.NewUser(
-		username,
-		fmt.Sprintf("%s:%s", hashedPassword, salt),
-		fmt.Sprintf("%s:%s", hashedMaster, msalt),
-	)
-
 	db, err := store.NewStore()
 	if err != nil {
 		utils.ErrorE(err)
@@ -65,7 +55,8 @@ func UserRegistration(username, password, masterkey string, Event *events.Event)
 		return
 	}
 
-	
+	// Release the badger lock before login reopens the store.
+	db.Close()
 	Event.Emit(consts.USER_LOGIN, nil)
 }
 
@@ -89,35 +80,53 @@ func UserLogin(username, password string, Event *events.Event) {
 	}
 	user, err := db.GetUser()
 	if err != nil {
+		db.Close()
 		failAttempt("Invalid username or password.", attemptPath, attempts)
 		return
 	}
 	if user.Username != username {
+		db.Close()
 		failAttempt("Invalid username or password.", attemptPath, attempts)
 		return
 	}
 
-	storedHash, salt, ok := splitHashSalt(user.Password)
-	if !ok {
-		failAttempt("Invalid username or password", attemptPath, attempts)
+	if !securecrypto.VerifySecret(password, user.Password, user.PasswordKDF) {
+		db.Close()
+		failAttempt("Invalid username or password.", attemptPath, attempts)
 		return
 	}
 
-	 inputHash, err := securecrypto.HashWithSalt(password,salt)
-	if err != nil || !bytes.Equal([]byte(storedHash), []byte(inputHash)) {
-		failAttempt("Invalid username or password.", attemptPath, attempts)
+	_, err = unlockVault(db, user, password)
+	if errors.Is(err, store.ErrManifestMissing) {
+		// Upgrades need the manifest; settle it first, then unlock again.
+		if checkIntegrity(db) {
+			_, err = unlockVault(db, user, password)
+		} else {
+			err = store.ErrManifestMissing
+		}
+	}
+	if err != nil {
+		db.Lock()
+		db.Close()
+		utils.ErrorE(err)
+		return
+	}
+
+	if !checkIntegrity(db) {
+		db.Lock()
+		db.Close()
 		return
 	}
 
 	_ = os.Remove(attemptPath)
 	utils.Done("User logged in successfully.")
 	Event.Username = username
-	Event.Emit(consts.USER_LOGGED_IN, &user, &db)
+	Event.Emit(consts.USER_LOGGED_IN, db, user)
 }
 
 func ResetPassword(username, oldPassword, newPassword string, Event *events.Event) {
 	username, oldPassword, newPassword = sanitizeCreds(username, oldPassword, newPassword)
-	if !isValidCreds(username, oldPassword, "") || !validatePassword(oldPassword) || !validatePassword(newPassword) {
+	if !isValidCreds(username, oldPassword, "") || !validatePassword(oldPassword) || !validatePassword(newPassword) || !notBreached(newPassword) {
 		return
 	}
 
@@ -144,40 +153,436 @@ func ResetPassword(username, oldPassword, newPassword string, Event *events.Even
 		return
 	}
 
-	oldHash, oldSalt, ok := splitHashSalt(user.Password)
-	if !ok {
-		failAttempt("Invalid stored password.", attemptPath, attempts)
+	if !securecrypto.VerifySecret(oldPassword, user.Password, user.PasswordKDF) {
+		failAttempt("Old password incorrect.", attemptPath, attempts)
 		return
 	}
 
-	checkOld, err := securecrypto.HashWithSalt(oldPassword,oldSalt)
-	if err != nil || !bytes.Equal([]byte(oldHash), []byte(checkOld)) {
-		failAttempt("Old password incorrect.", attemptPath, attempts)
+	dek, err := unlockVault(db, user, oldPassword)
+	if err != nil {
+		utils.ErrorE(err)
 		return
 	}
+	defer db.Lock()
+
+	// Only the wrapped data key changes, so one record write is the reset.
+	if err := setPassword(user, dek, newPassword); err != nil {
+		utils.ErrorE(err)
+		return
+	}
+	if err := db.UpdateUser(user); err != nil {
+		utils.ErrorE(err)
+		return
+	}
+
+	_ = os.Remove(attemptPath)
+	utils.Done("Password reset successful.")
+	db.Lock()
+	db.Close()
+	Event.Emit(consts.USER_LOGIN, nil)
+}
 
-	newSalt, err := securecrypto.GenerateSalt()
+// RecoverVault unlocks the vault with the master key and replaces the
+// login password, for when the password is forgotten.
+func RecoverVault(username, masterKey, newPassword, confirm string, Event *events.Event) {
+	username, masterKey, newPassword = sanitizeCreds(username, masterKey, newPassword)
+	if username == "" || masterKey == "" {
+		utils.Warn("Provide username and master key.")
+		return
+	}
+	if newPassword != strings.TrimSpace(confirm) {
+		utils.Warn("Passwords do not match.")
+		return
+	}
+	if !isValidCreds(username, newPassword, masterKey) || !validatePassword(newPassword) || !notBreached(newPassword) {
+		return
+	}
+
+	attemptPath := getAttemptsFilePath()
+	attempts := readAttempts(attemptPath)
+	if handleExcessiveAttempts(attempts, attemptPath) {
+		return
+	}
+
+	db, err := store.NewStore()
 	if err != nil {
 		utils.ErrorE(err)
 		return
 	}
+	defer db.Close()
+
+	user, err := db.GetUser()
+	if err != nil || user.Username != username {
+		failAttempt("Invalid username or master key.", attemptPath, attempts)
+		return
+	}
+	// Vaults registered before the master key hash was persisted can only
+	// be checked by unwrapping.
+	if len(user.MasterKey) > 0 && !user.IsMaster(masterKey) {
+		failAttempt("Invalid username or master key.", attemptPath, attempts)
+		return
+	}
+	if len(user.MasterWrappedKey) == 0 {
+		utils.Warn("Master key recovery is not set up for this vault.")
+		return
+	}
 
-	newHash, err := securecrypto.HashWithSalt(newPassword, newSalt)
+	dek, params, err := securecrypto.UnwrapKey(user.MasterWrappedKey, []byte(masterKey), wrapContext(user, wrapMaster))
 	if err != nil {
+		failAttempt("Invalid username or master key.", attemptPath, attempts)
+		return
+	}
+	if err := db.Unlock(dek); err != nil {
 		utils.ErrorE(err)
 		return
 	}
+	defer db.Lock()
 
-	user.Password = []byte(fmt.Sprintf("%s:%s", newHash, newSalt))
+	if err := setPassword(user, dek, newPassword); err != nil {
+		utils.ErrorE(err)
+		return
+	}
+	if len(user.MasterKey) == 0 || params.Outdated() || user.MasterKDF.Outdated() {
+		if err := setMasterKey(user, dek, masterKey); err != nil {
+			utils.ErrorE(err)
+			return
+		}
+	}
 	if err := db.UpdateUser(user); err != nil {
 		utils.ErrorE(err)
 		return
 	}
 
+	_ = os.Remove(attemptPath)
+	utils.Done("Vault recovered. Log in with your new password.")
+	db.Lock()
+	db.Close()
+	Event.Emit(consts.USER_LOGIN, nil)
+}
+
+// RotateVaultKey verifies both secrets, then moves the vault to a fresh
+// data key. db and user come from an open dashboard session, or are nil
+// when called from the -rekey flag and username must be given.
+func RotateVaultKey(db *store.Store, user *models.User, username, password, masterKey string) {
+	username, password, masterKey = sanitizeCreds(username, password, masterKey)
+
+	attemptPath := getAttemptsFilePath()
+	attempts := readAttempts(attemptPath)
+	if handleExcessiveAttempts(attempts, attemptPath) {
+		return
+	}
+
+	if db == nil {
+		var err error
+		if db, err = store.NewStore(); err != nil {
+			utils.ErrorE(err)
+			return
+		}
+		defer db.Close()
+		if user, err = db.GetUser(); err != nil || user.Username != username {
+			failAttempt("Invalid username or password.", attemptPath, attempts)
+			return
+		}
+	}
+
+	if !securecrypto.VerifySecret(password, user.Password, user.PasswordKDF) {
+		failAttempt("Invalid password.", attemptPath, attempts)
+		return
+	}
+	if len(user.MasterKey) > 0 && !user.IsMaster(masterKey) {
+		failAttempt("Invalid master key.", attemptPath, attempts)
+		return
+	}
+	if len(user.MasterKey) == 0 {
+		if !isValidCreds(username, password, masterKey) {
+			return
+		}
+		utils.Warn("No master key on record; the one entered becomes your recovery key.")
+	}
+
+	if !db.Unlocked() {
+		if _, err := unlockVault(db, user, password); err != nil {
+			utils.ErrorE(err)
+			return
+		}
+		defer db.Lock()
+	}
+
+	if err := rekeyVault(db, user, password, masterKey); err != nil {
+		utils.ErrorE(err)
+		return
+	}
+
+	_ = os.Remove(attemptPath)
+	utils.Done("Vault key rotated; every entry is sealed under the new key.")
+}
 
+// BackupVault writes the user record and every entry to path as an archive
+// sealed under passphrase, which is kept apart from the login secrets so a
+// backup can be opened without them.
+func BackupVault(username, password, path, passphrase, confirm string) {
+	username, password, passphrase = sanitizeCreds(username, password, passphrase)
+	path = strings.TrimSpace(path)
+	if path == "" {
+		utils.Warn("Provide a backup file path.")
+		return
+	}
+	if utils.FileExists(path) {
+		utils.Warn("Backup file already exists: " + path)
+		return
+	}
+	if len(passphrase) < 12 {
+		utils.Warn("Backup passphrase must be at least 12 characters.")
+		return
+	}
+	if passphrase != strings.TrimSpace(confirm) {
+		utils.Warn("Passphrases do not match.")
+		return
+	}
+	if passphrase == password {
+		utils.Warn("Use a backup passphrase that differs from your password.")
+		return
+	}
 
+	attemptPath := getAttemptsFilePath()
+	attempts := readAttempts(attemptPath)
+	if handleExcessiveAttempts(attempts, attemptPath) {
+		return
+	}
+
+	db, err := store.NewStore()
+	if err != nil {
+		utils.ErrorE(err)
+		return
+	}
+	defer db.Close()
+
+	user, err := db.GetUser()
+	if err != nil || user.Username != username {
+		failAttempt("Invalid username or password.", attemptPath, attempts)
+		return
+	}
+	if !securecrypto.VerifySecret(password, user.Password, user.PasswordKDF) {
+		failAttempt("Invalid username or password.", attemptPath, attempts)
+		return
+	}
+	if _, err := unlockVault(db, user, password); err != nil {
+		utils.ErrorE(err)
+		return
+	}
+	defer db.Lock()
+
+	entries, err := db.GetAllForUser(user.Username)
+	if err != nil {
+		utils.ErrorE(err)
+		return
+	}
 	_ = os.Remove(attemptPath)
-	utils.Done("Password reset successful.")
+
+	trashed, err := db.GetTrash(user.Username)
+	if err != nil {
+		utils.ErrorE(err)
+		return
+	}
+
+	if err := writeBackup(path, []byte(passphrase), user, entries, trashed); err != nil {
+		utils.ErrorE(err)
+		return
+	}
+	if len(trashed) > 0 {
+		utils.Done(fmt.Sprintf("Backed up %d entries and %d in the trash to %s.", len(entries), len(trashed), path))
+	} else {
+		utils.Done(fmt.Sprintf("Backed up %d entries to %s.", len(entries), path))
+	}
+}
+
+// SearchVault unlocks the vault just long enough to print the entries
+// matching query.
+func SearchVault(username, password, query string) {
+	username, password, _ = sanitizeCreds(username, password, "")
+	if !isValidCreds(username, password, "") {
+		return
+	}
+
+	attemptPath := getAttemptsFilePath()
+	attempts := readAttempts(attemptPath)
+	if handleExcessiveAttempts(attempts, attemptPath) {
+		return
+	}
+
+	db, err := store.NewStore()
+	if err != nil {
+		utils.ErrorE(err)
+		return
+	}
+	defer db.Close()
+
+	user, err := db.GetUser()
+	if err != nil || user.Username != username {
+		failAttempt("Invalid username or password.", attemptPath, attempts)
+		return
+	}
+	if !securecrypto.VerifySecret(password, user.Password, user.PasswordKDF) {
+		failAttempt("Invalid username or password.", attemptPath, attempts)
+		return
+	}
+	if _, err := unlockVault(db, user, password); err != nil {
+		utils.ErrorE(err)
+		return
+	}
+	defer db.Lock()
+
+	entries, err := db.GetAllForUser(user.Username)
+	if err != nil {
+		utils.ErrorE(err)
+		return
+	}
+	_ = os.Remove(attemptPath)
+
+	if dashboard.PrintMatches(os.Stdout, query, entries) == 0 {
+		fmt.Println("No entries match", query)
+	}
+}
+
+// writeBackup writes the archive next to path and renames it into place,
+// so a failure never leaves a partial backup behind.
+func writeBackup(path string, passphrase []byte, user *models.User, entries map[string]types.Data, trashed []store.Trashed) error {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	w, err := backup.NewWriter(f, passphrase, user)
+	if err == nil {
+		for label, data := range entries {
+			if err = w.Add(types.Label(label), data); err != nil {
+				break
+			}
+		}
+	}
+	if err == nil {
+		for _, t := range trashed {
+			if err = w.AddTrashed(t.Label, t.Data, t.Deleted); err != nil {
+				break
+			}
+		}
+	}
+	if err == nil {
+		err = w.Close()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = checkBackup(tmp, passphrase, len(entries), len(trashed))
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// checkBackup reads a freshly written archive back in full, so a backup
+// that would not restore is never put in place.
+func checkBackup(path string, passphrase []byte, entries, trashed int) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	archive, err := backup.Read(f, passphrase)
+	if err != nil {
+		return fmt.Errorf("backup does not read back: %w", err)
+	}
+	if archive.User == nil || len(archive.Entries) != entries || len(archive.Trash) != trashed {
+		return fmt.Errorf("backup reads back with %d of %d entries and %d of %d in the trash",
+			len(archive.Entries), entries, len(archive.Trash), trashed)
+	}
+	return nil
+}
+
+// RestoreVault replaces the vault with the content of an archive. The
+// archive is decrypted and checked in full, and the archived account's
+// password must unwrap its data key, before the current vault is touched.
+func RestoreVault(path, passphrase, password string, confirm func() bool, Event *events.Event) {
+	path = strings.TrimSpace(path)
+	f, err := os.Open(path)
+	if err != nil {
+		utils.ErrorE(err)
+		return
+	}
+	archive, err := backup.Read(f, []byte(strings.TrimSpace(passphrase)))
+	f.Close()
+	if err != nil {
+		utils.Error("Backup rejected: " + err.Error())
+		return
+	}
+
+	user := archive.User
+	if user == nil {
+		utils.Error("Backup rejected: " + archive.Name + " is a sealed export, not a vault backup.")
+		return
+	}
+	password = strings.TrimSpace(password)
+	if len(user.WrappedKey) == 0 || !securecrypto.VerifySecret(password, user.Password, user.PasswordKDF) {
+		utils.Warn("Password does not match the backed up account.")
+		return
+	}
+	dek, _, err := securecrypto.UnwrapKey(user.WrappedKey, []byte(password), wrapContext(user, wrapPassword))
+	if err != nil {
+		utils.Error("Backup rejected: " + err.Error())
+		return
+	}
+
+	entries := make(map[string]types.Data, len(archive.Entries))
+	for _, e := range archive.Entries {
+		if _, dup := entries[string(e.Label)]; dup || e.Label == "" {
+			utils.Error(fmt.Sprintf("Backup rejected: invalid or duplicate entry %q.", e.Label))
+			return
+		}
+		entries[string(e.Label)] = e.Data
+	}
+	trash := make([]store.Trashed, 0, len(archive.Trash))
+	for _, e := range archive.Trash {
+		if e.Label == "" {
+			utils.Error("Backup rejected: trashed entry without a label.")
+			return
+		}
+		trash = append(trash, store.Trashed{Label: e.Label, Data: e.Data, Deleted: e.Deleted})
+	}
+
+	utils.Done(fmt.Sprintf("Backup of %q from %s verified: %d entries, %d in the trash.",
+		user.Username, archive.Created.Local().Format("2006-01-02 15:04"), len(entries), len(trash)))
+
+	db, err := store.NewStore()
+	if err != nil {
+		utils.ErrorE(err)
+		return
+	}
+	defer db.Close()
+
+	exists, err := db.IsUser()
+	if err != nil {
+		utils.ErrorE(err)
+		return
+	}
+	if exists && !confirm() {
+		utils.Warn("Restore cancelled; the current vault is unchanged.")
+		return
+	}
+
+	if err := db.Replace(user, dek, entries, trash); err != nil {
+		utils.ErrorE(err)
+		return
+	}
+	db.Lock()
+	db.Close()
+	utils.Done("Vault restored. Log in with the backed up account.")
 	Event.Emit(consts.USER_LOGIN, nil)
 }
 
@@ -212,14 +617,7 @@ func DropStorage(username, currentPassword string) {
 		failAttempt("Invalid username or password.", attemptPath, attempts)
 		return
 	}
-	storedHash, salt, ok := splitHashSalt(user.Password)
-	if !ok {
-		failAttempt("Stored password invalid.", attemptPath, attempts)
-		return
-	}
-
-	checkHash, err := securecrypto.HashWithSalt(currentPassword,salt)
-	if err != nil || !bytes.Equal([]byte(storedHash), []byte(checkHash)) {
+	if !securecrypto.VerifySecret(currentPassword, user.Password, user.PasswordKDF) {
 		failAttempt("Invalid password.", attemptPath, attempts)
 		return
 	}
@@ -235,6 +633,64 @@ func UserLogout() {
 
 // --------- Helpers ---------
 
+// checkIntegrity verifies the vault manifest and reports anything missing,
+// extra or rolled back. It returns false if the user declines to continue.
+func checkIntegrity(db *store.Store) bool {
+	report, err := db.Verify()
+	if err != nil {
+		utils.Error("Integrity check failed: " + err.Error())
+		return false
+	}
+	if report.Initialized {
+		utils.Warn("No vault manifest found; created one from the current entries.")
+	}
+	if report.OK() {
+		return true
+	}
+
+	if report.Deleted {
+		utils.Warn("The vault manifest has been deleted. Entries deleted along with it cannot be detected.")
+		answer := promptForInput("Rebuild the manifest from the entries present now? Type REBUILD to continue: ")
+		if answer != "REBUILD" {
+			return false
+		}
+		if err := db.RebuildManifest(); err != nil {
+			utils.Error("Failed to rebuild the manifest: " + err.Error())
+			return false
+		}
+		utils.Done("Vault manifest rebuilt.")
+		return checkIntegrity(db)
+	}
+
+	if report.Tampered {
+		utils.Warn("The vault manifest has been modified outside rook.")
+	}
+	if report.RolledBack {
+		utils.Warn("The vault is older than the last state rook saved: it may have been rolled back.")
+	}
+	if report.AnchorMissing {
+		utils.Warn("The rollback anchor is missing.")
+	}
+	if report.AnchorInvalid {
+		utils.Warn("The rollback anchor does not match this vault key.")
+	}
+	for _, label := range report.Stale {
+		utils.Warn("Entry replaced by an older or unexpected version: " + label)
+	}
+	for _, label := range report.Extra {
+		utils.Warn("Entry not recorded in the manifest: " + label)
+	}
+	if n := len(report.Missing); n > 0 {
+		utils.Warn(fmt.Sprintf("%d entries recorded in the manifest are missing.", n))
+	}
+	if n := len(report.Unreadable); n > 0 {
+		utils.Warn(fmt.Sprintf("%d entries cannot be decrypted.", n))
+	}
+
+	answer := promptForInput("Open the dashboard anyway? [y/N]: ")
+	return strings.EqualFold(answer, "y")
+}
+
 func sanitizeCreds(u, p, m string) (string, string, string) {
 	return strings.TrimSpace(u), strings.TrimSpace(p), strings.TrimSpace(m)
 }
@@ -263,6 +719,31 @@ func validatePassword(p string) bool {
 	return true
 }
 
+// notBreached rejects a master password found in the local breach data, if
+// one is configured.
+func notBreached(p string) bool {
+	pwned, err := breach.OpenConfigured()
+	if err != nil {
+		utils.Warn("Breach check skipped: " + err.Error())
+		return true
+	}
+	if pwned == nil {
+		return true
+	}
+	defer pwned.Close()
+
+	count, err := pwned.Count([]byte(p))
+	if err != nil {
+		utils.Warn("Breach check skipped: " + err.Error())
+		return true
+	}
+	if count > 0 {
+		utils.Warn(fmt.Sprintf("This password appears %d times in known data breaches. Choose another.", count))
+		return false
+	}
+	return true
+}
+
 func getAttemptsFilePath() string {
 	dir, err := utils.GetSessionDir()
 	if err != nil {
@@ -303,10 +784,3 @@ func handleExcessiveAttempts(attempts int, path string) bool {
 	return false
 }
 
-func splitHashSalt(combined []byte) (hash string, salt string, ok bool) {
-	parts := strings.Split(string(combined), ":")
-	if len(parts) != 2 {
-		return "", "", false
-	}
-	return parts[0], parts[1], true
-}
\ No newline at end of file
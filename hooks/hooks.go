@@ -8,6 +8,7 @@ import (
 
 	"github.com/mbbgs/rook/consts"
 	"github.com/mbbgs/rook/events"
+	"github.com/mbbgs/rook/models"
 	"github.com/mbbgs/rook/terms"
 	"github.com/mbbgs/rook/utils"
 	"github.com/mbbgs/rook/views"
@@ -64,6 +65,7 @@ func SetupEventHandlers() {
 	}
 	
 	exists, err := loadStore.IsUser()
+	loadStore.Close()
 	if err != nil {
 		utils.Error("Failed to check user existence: " + err.Error())
 		os.Exit(1)
@@ -78,7 +80,7 @@ func SetupEventHandlers() {
 })
 
 	Event.On(consts.USER_LOGIN, func(_ ...interface{}) {
-		fmt.Println("[ Login Cell ]\n")
+		fmt.Print("[ Login Cell ]\n\n")
 
 	//	for attempts := 0; attempts < 3; attempts++ {
 			username, password := promptForCredentials()
@@ -91,7 +93,7 @@ func SetupEventHandlers() {
 	})
 
 	Event.On(consts.USER_REGISTRATION, func(_ ...interface{}) {
-		fmt.Println("[ Registration Cell ]\n")
+		fmt.Print("[ Registration Cell ]\n\n")
 		username, password := promptForCredentials()
 		masterKey := promptForInput("choose your Master key: ")
 
@@ -106,6 +108,61 @@ func SetupEventHandlers() {
 		ResetPassword(username,currPassword, newPassword, Event)
 	})
 
+	Event.On(consts.RECOVER_VAULT, func(_ ...interface{}) {
+		fmt.Print("[ Recovery Cell ]\n\n")
+		username := promptForInput("Enter your username: ")
+		masterKey := promptForPassword("Enter your master key: ")
+		newPassword := promptForPassword("Choose a new password: ")
+		confirm := promptForPassword("Confirm the new password: ")
+		RecoverVault(username, masterKey, newPassword, confirm, Event)
+	})
+
+	// Emitted with (store, user) from the dashboard, or with nothing from
+	// the -rekey flag.
+	Event.On(consts.REKEY_VAULT, func(args ...interface{}) {
+		fmt.Print("[ Rekey Cell ]\n\n")
+		db, user := sessionArgs(args)
+		username := ""
+		if user == nil {
+			username = promptForInput("Enter your username: ")
+		} else {
+			username = user.Username
+		}
+		password := promptForPassword("Enter your password: ")
+		masterKey := promptForPassword("Enter your master key: ")
+		RotateVaultKey(db, user, username, password, masterKey)
+	})
+
+	// Both are emitted with the archive path from the -backup and -restore
+	// flags.
+	Event.On(consts.BACKUP_VAULT, func(args ...interface{}) {
+		fmt.Print("[ Backup Cell ]\n\n")
+		path, _ := args[0].(string)
+		username, password := promptForCredentials()
+		passphrase := promptForPassword("Choose a backup passphrase: ")
+		confirm := promptForPassword("Confirm the backup passphrase: ")
+		BackupVault(username, password, path, passphrase, confirm)
+	})
+
+	Event.On(consts.RESTORE_VAULT, func(args ...interface{}) {
+		fmt.Print("[ Restore Cell ]\n\n")
+		path, _ := args[0].(string)
+		passphrase := promptForPassword("Enter the backup passphrase: ")
+		password := promptForPassword("Enter the password of the backed up account: ")
+		RestoreVault(path, passphrase, password, func() bool {
+			answer := promptForInput("This replaces the current vault and all its entries. Type RESTORE to continue: ")
+			return answer == "RESTORE"
+		}, Event)
+	})
+
+	// Emitted with the query from "rook search"; prints matches and exits
+	// without opening the dashboard.
+	Event.On(consts.SEARCH_VAULT, func(args ...interface{}) {
+		query, _ := args[0].(string)
+		username, password := promptForCredentials()
+		SearchVault(username, password, query)
+	})
+
 	Event.On(consts.DROP_TABLE, func(_ ...interface{}) {
 		username := strings.TrimSpace(Event.Username)
 		
@@ -121,7 +178,7 @@ func SetupEventHandlers() {
 		utils.SilentDone(consts.USER_LOGIN)
 		Event.Off(consts.USER_LOGIN)
 		
-		dash := dashboard.NewDashboard(args[0],args[1])
+		dash := dashboard.NewDashboard(args[0],args[1], Event)
 		//	if err != nil {
 		//	utils.Error("Failed to init dashboard: " + err.Error())
 		//	return
@@ -171,3 +228,15 @@ func promptForPassword(prompt string) string {
 	return strings.TrimSpace(string(bytePassword))
 }
 
+// sessionArgs unpacks the (store, user) pair the dashboard emits with.
+func sessionArgs(args []interface{}) (*store.Store, *models.User) {
+	if len(args) != 2 {
+		return nil, nil
+	}
+	db, ok1 := args[0].(*store.Store)
+	user, ok2 := args[1].(*models.User)
+	if !ok1 || !ok2 {
+		return nil, nil
+	}
+	return db, user
+}
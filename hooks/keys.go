@@ -0,0 +1,233 @@
+package hooks
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mbbgs/rook/models"
+	"github.com/mbbgs/rook/securecrypto"
+	"github.com/mbbgs/rook/store"
+	"github.com/mbbgs/rook/utils"
+)
+
+// Entries are sealed with a random data key. The user record keeps that key
+// wrapped under a key derived from the login password and, separately, under
+// one derived from the master key, so changing either secret only rewraps.
+
+const (
+	wrapPassword = "password"
+	wrapMaster   = "master"
+)
+
+// wrapContext binds a wrapped key to its owner and purpose.
+func wrapContext(user *models.User, purpose string) []byte {
+	return []byte("rook:" + purpose + ":" + user.Username)
+}
+
+func wrapDataKey(user *models.User, dek []byte, secret, purpose string) ([]byte, error) {
+	params, err := securecrypto.DefaultKDF()
+	if err != nil {
+		return nil, err
+	}
+	return securecrypto.WrapKey(dek, []byte(secret), params, wrapContext(user, purpose))
+}
+
+// setPassword rehashes password and rewraps dek under it. The caller
+// persists user; a single record write keeps the change atomic.
+func setPassword(user *models.User, dek []byte, password string) error {
+	hashed, costs, err := securecrypto.HashSecret(password)
+	if err != nil {
+		return err
+	}
+	wrapped, err := wrapDataKey(user, dek, password, wrapPassword)
+	if err != nil {
+		return err
+	}
+	user.Password = []byte(hashed)
+	user.PasswordKDF = costs
+	user.WrappedKey = wrapped
+	user.UpdatedAt = time.Now()
+	return nil
+}
+
+// setMasterKey rehashes masterKey and rewraps dek under it.
+func setMasterKey(user *models.User, dek []byte, masterKey string) error {
+	hashed, costs, err := securecrypto.HashSecret(masterKey)
+	if err != nil {
+		return err
+	}
+	wrapped, err := wrapDataKey(user, dek, masterKey, wrapMaster)
+	if err != nil {
+		return err
+	}
+	user.MasterKey = []byte(hashed)
+	user.MasterKDF = costs
+	user.MasterWrappedKey = wrapped
+	user.UpdatedAt = time.Now()
+	return nil
+}
+
+// rekeyVault replaces the data key of an unlocked vault: every entry is
+// re-encrypted and the new key is wrapped under password and masterKey. user
+// is only updated once the store has committed and verified the change.
+func rekeyVault(db *store.Store, user *models.User, password, masterKey string) error {
+	dek, err := securecrypto.NewDataKey()
+	if err != nil {
+		return err
+	}
+	next := *user
+	if err := setPassword(&next, dek, password); err != nil {
+		return err
+	}
+	if err := setMasterKey(&next, dek, masterKey); err != nil {
+		return err
+	}
+	if err := db.Rekey(&next, dek); err != nil {
+		return err
+	}
+	*user = next
+	return nil
+}
+
+// unlockVault unwraps the data key with an already verified password and
+// unlocks db with it. Vaults without a data key are migrated first, and
+// outdated KDF parameters are upgraded while the password is at hand.
+// Recovery is set up if missing, and the schema is brought up to date last.
+func unlockVault(db *store.Store, user *models.User, password string) ([]byte, error) {
+	if len(user.WrappedKey) == 0 {
+		dek, err := migrateToDataKey(db, user, password)
+		if err != nil {
+			return nil, err
+		}
+		ensureRecovery(db, user, dek)
+		return dek, migrateSchema(db)
+	}
+
+	dek, params, err := securecrypto.UnwrapKey(user.WrappedKey, []byte(password), wrapContext(user, wrapPassword))
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Unlock(dek); err != nil {
+		return nil, err
+	}
+	if err := upgradeEntryKeys(db, user); err != nil {
+		return nil, err
+	}
+
+	if params.Outdated() || user.PasswordKDF.Outdated() {
+		err := setPassword(user, dek, password)
+		if err == nil {
+			err = db.UpdateUser(user)
+		}
+		if err != nil {
+			utils.Warn("Could not upgrade key derivation: " + err.Error())
+		} else {
+			utils.Done("Vault key derivation upgraded to Argon2id.")
+		}
+	}
+	ensureRecovery(db, user, dek)
+	return dek, migrateSchema(db)
+}
+
+// ensureRecovery wraps dek under the master key when the vault has no
+// master-wrapped copy, as for vaults moved to a data key before recovery
+// was carried over. Until that is done it asks again at every unlock.
+func ensureRecovery(db *store.Store, user *models.User, dek []byte) {
+	if len(user.MasterWrappedKey) > 0 {
+		return
+	}
+	utils.Warn("Master key recovery is not set up for this vault.")
+	masterKey := promptForPassword("Enter your master key to set it up (empty to skip): ")
+	switch {
+	case masterKey == "":
+		utils.Warn("Recovery skipped; you will be asked again at the next login.")
+		return
+	case len(user.MasterKey) > 0 && !user.IsMaster(masterKey):
+		utils.Warn("That is not your master key; you will be asked again at the next login.")
+		return
+	case len(user.MasterKey) == 0 && promptForPassword("No master key on record; confirm the one entered: ") != masterKey:
+		utils.Warn("Master keys do not match; you will be asked again at the next login.")
+		return
+	}
+
+	next := *user
+	err := setMasterKey(&next, dek, masterKey)
+	if err == nil {
+		err = db.UpdateUser(&next)
+	}
+	if err != nil {
+		utils.Warn("Could not set up recovery: " + err.Error())
+		return
+	}
+	*user = next
+	utils.Done("Master key recovery set up.")
+}
+
+// migrateToDataKey re-encrypts a vault sealed with a password-derived key
+// under a fresh data key, in one transaction with the new user record.
+// Vaults from before entries were encrypted have no key salt; their plain
+// entries are sealed under the data key directly.
+func migrateToDataKey(db *store.Store, user *models.User, password string) ([]byte, error) {
+	dek, err := securecrypto.NewDataKey()
+	if err != nil {
+		return nil, err
+	}
+	oldKey := dek
+	if params := user.KDF; params.Algorithm != securecrypto.KDFNone || len(user.KeySalt) > 0 {
+		if params.Algorithm == securecrypto.KDFNone {
+			params = securecrypto.LegacyKDF(user.KeySalt)
+		}
+		if oldKey, err = securecrypto.DeriveKey([]byte(password), params); err != nil {
+			return nil, err
+		}
+	}
+	if err := db.Unlock(oldKey); err != nil {
+		return nil, err
+	}
+	if err := upgradeEntryKeys(db, user); err != nil {
+		return nil, err
+	}
+
+	if err := setPassword(user, dek, password); err != nil {
+		return nil, err
+	}
+	user.KDF = securecrypto.KDFParams{}
+	user.KeySalt = nil
+
+	if err := db.Rekey(user, dek); err != nil {
+		return nil, err
+	}
+	utils.Done("Vault moved to a wrapped data key.")
+	return dek, nil
+}
+
+// upgradeEntryKeys replaces readable "username:label" badger keys with
+// blind indexes.
+func upgradeEntryKeys(db *store.Store, user *models.User) error {
+	moved, err := db.UpgradeKeys(user.Username)
+	if err != nil {
+		return err
+	}
+	if moved > 0 {
+		utils.Done(fmt.Sprintf("Moved %d entries to blind-indexed keys.", moved))
+	}
+	return nil
+}
+
+// migrateSchema upgrades an unlocked vault written by an earlier rook.
+func migrateSchema(db *store.Store) error {
+	result, err := db.Migrate()
+	if err != nil {
+		return err
+	}
+	if len(result.Applied) > 0 {
+		utils.Done(fmt.Sprintf("Vault upgraded from schema %d to %d.", result.From, result.To))
+		for _, name := range result.Applied {
+			utils.Done("  - " + name)
+		}
+	}
+	if result.Backup != "" {
+		utils.Warn(fmt.Sprintf("The upgraded vault did not verify; the previous state is kept at %s.", result.Backup))
+	}
+	return nil
+}
@@ -0,0 +1,226 @@
+package interchange
+
+import (
+	"encoding/json"
+	"io"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mbbgs/rook/types"
+)
+
+// Bitwarden's unencrypted JSON export, reduced to what rook fills in.
+type bwExport struct {
+	Encrypted bool       `json:"encrypted"`
+	Folders   []bwFolder `json:"folders"`
+	Items     []bwItem   `json:"items"`
+}
+
+type bwFolder struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type bwItem struct {
+	ID             string    `json:"id"`
+	OrganizationID *string   `json:"organizationId"`
+	FolderID       *string   `json:"folderId"`
+	Type           int       `json:"type"`
+	Reprompt       int       `json:"reprompt"`
+	Name           string    `json:"name"`
+	Notes          *string   `json:"notes"`
+	Favorite       bool      `json:"favorite"`
+	Login          *bwLogin  `json:"login,omitempty"`
+	SecureNote     *bwNote   `json:"secureNote,omitempty"`
+	Card           *bwCard   `json:"card,omitempty"`
+	Identity       *bwID     `json:"identity,omitempty"`
+	Fields         []bwField `json:"fields,omitempty"`
+	CollectionIDs  []string  `json:"collectionIds"`
+}
+
+type bwLogin struct {
+	URIs     []bwURI `json:"uris"`
+	Username *string `json:"username"`
+	Password *string `json:"password"`
+	TOTP     *string `json:"totp"`
+}
+
+type bwURI struct {
+	Match *int   `json:"match"`
+	URI   string `json:"uri"`
+}
+
+type bwNote struct {
+	Type int `json:"type"`
+}
+
+type bwCard struct {
+	CardholderName *string `json:"cardholderName"`
+	Brand          *string `json:"brand"`
+	Number         *string `json:"number"`
+	ExpMonth       *string `json:"expMonth"`
+	ExpYear        *string `json:"expYear"`
+	Code           *string `json:"code"`
+}
+
+type bwID struct {
+	FirstName      *string `json:"firstName"`
+	LastName       *string `json:"lastName"`
+	Country        *string `json:"country"`
+	PassportNumber *string `json:"passportNumber"`
+	LicenseNumber  *string `json:"licenseNumber"`
+}
+
+type bwField struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+	Type  int    `json:"type"`
+}
+
+// Bitwarden item types.
+const (
+	bwTypeLogin    = 1
+	bwTypeNote     = 2
+	bwTypeCard     = 3
+	bwTypeIdentity = 4
+)
+
+// Bitwarden custom field types.
+const (
+	bwFieldText   = 0
+	bwFieldHidden = 1
+)
+
+// WriteBitwarden writes records as a Bitwarden unencrypted JSON export.
+func WriteBitwarden(w io.Writer, records []Record) error {
+	out := bwExport{Folders: []bwFolder{}, Items: make([]bwItem, 0, len(records))}
+
+	folders := make(map[string]string)
+	for _, r := range records {
+		if r.Folder != "" && folders[r.Folder] == "" {
+			folders[r.Folder] = newUUID()
+		}
+	}
+	names := make([]string, 0, len(folders))
+	for name := range folders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		out.Folders = append(out.Folders, bwFolder{ID: folders[name], Name: name})
+	}
+
+	for _, r := range records {
+		item := bwItem{
+			ID:    newUUID(),
+			Name:  r.Label,
+			Notes: optional(r.Notes),
+		}
+		// Fields the item type has a place for are moved there; the rest
+		// stay custom fields.
+		fields := slices.Clone(r.Fields)
+		switch r.kind() {
+		case types.KindLogin, types.KindDatabase:
+			item.Type = bwTypeLogin
+			item.Login = &bwLogin{
+				URIs:     []bwURI{},
+				Username: optional(r.Username),
+				Password: optional(r.Password),
+				TOTP:     optional(r.TOTP),
+			}
+			if r.URL != "" {
+				item.Login.URIs = append(item.Login.URIs, bwURI{URI: r.URL})
+			}
+			for _, u := range r.URLs {
+				item.Login.URIs = append(item.Login.URIs, bwURI{URI: u})
+			}
+		case types.KindCard:
+			item.Type, item.Card = bwTypeCard, bwCardOf(&fields)
+		case types.KindIdentity:
+			item.Type, item.Identity = bwTypeIdentity, bwIDOf(&fields)
+		default:
+			// Bitwarden has no SSH key or token items that import
+			// reliably, so those go in as notes with their fields.
+			item.Type, item.SecureNote = bwTypeNote, &bwNote{}
+		}
+		for _, f := range fields {
+			field := bwField{Name: f.Name, Value: f.Value, Type: bwFieldText}
+			if f.Type == types.FieldHidden {
+				field.Type = bwFieldHidden
+			}
+			item.Fields = append(item.Fields, field)
+		}
+		if r.Folder != "" {
+			id := folders[r.Folder]
+			item.FolderID = &id
+		}
+		out.Items = append(out.Items, item)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	return enc.Encode(out)
+}
+
+// bwCardOf moves a card's fields out of fields into a Bitwarden card.
+func bwCardOf(fields *[]types.Field) *bwCard {
+	card := &bwCard{
+		CardholderName: takeField(fields, "Cardholder"),
+		Number:         takeField(fields, "Number"),
+		Code:           takeField(fields, "CVV"),
+	}
+	if expiry, err := types.CardExpiry(fieldValue(*fields, "Expiry")); err == nil {
+		last := expiry.AddDate(0, -1, 0)
+		card.ExpMonth = optional(strconv.Itoa(int(last.Month())))
+		card.ExpYear = optional(strconv.Itoa(last.Year()))
+		takeField(fields, "Expiry")
+	}
+	return card
+}
+
+// bwIDOf moves an identity document's fields out of fields into a
+// Bitwarden identity. The number only has a place there for passports and
+// driving licences.
+func bwIDOf(fields *[]types.Field) *bwID {
+	id := &bwID{Country: takeField(fields, "Country")}
+	if name := fieldValue(*fields, "Full name"); name != "" {
+		first, last := name, ""
+		if i := strings.LastIndexByte(name, ' '); i > 0 {
+			first, last = name[:i], name[i+1:]
+		}
+		id.FirstName, id.LastName = optional(first), optional(last)
+		takeField(fields, "Full name")
+	}
+	switch document := strings.ToLower(fieldValue(*fields, "Document")); {
+	case strings.Contains(document, "passport"):
+		id.PassportNumber = takeField(fields, "Number")
+	case strings.Contains(document, "licen"):
+		id.LicenseNumber = takeField(fields, "Number")
+	}
+	return id
+}
+
+func fieldValue(fields []types.Field, name string) string {
+	return types.Data{Fields: fields}.Field(name)
+}
+
+// takeField removes the named field from fields and returns its value.
+func takeField(fields *[]types.Field, name string) *string {
+	for i, f := range *fields {
+		if f.Name == name {
+			*fields = slices.Delete(*fields, i, i+1)
+			return optional(f.Value)
+		}
+	}
+	return nil
+}
+
+func optional(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
@@ -0,0 +1,294 @@
+package interchange
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/mbbgs/rook/types"
+)
+
+// Format names the source of imported records.
+type Format string
+
+const (
+	Chrome    Format = "Chrome/Chromium"
+	Firefox   Format = "Firefox"
+	Bitwarden Format = "Bitwarden"
+	LastPass  Format = "LastPass"
+	OnePass   Format = "1Password"
+	Generic   Format = "rook"
+	KDBX      Format = "KeePass (KDBX 4)"
+)
+
+// columns maps lower-cased header names to their index.
+type columns map[string]int
+
+func (c columns) has(names ...string) bool {
+	for _, name := range names {
+		if _, ok := c[name]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// get returns the first of names present in row.
+func (c columns) get(row []string, names ...string) string {
+	for _, name := range names {
+		if i, ok := c[name]; ok && i < len(row) {
+			return strings.TrimSpace(row[i])
+		}
+	}
+	return ""
+}
+
+// detect picks the dialect from the header. The most specific signatures
+// are tried first since several exporters share url/username/password.
+func detect(c columns) (Format, error) {
+	switch {
+	case c.has("login_username", "login_password"):
+		return Bitwarden, nil
+	case c.has("url", "username", "password") && (c.has("httprealm") || c.has("formactionorigin")):
+		return Firefox, nil
+	case c.has("url", "username", "password", "grouping") || c.has("url", "username", "password", "extra"):
+		return LastPass, nil
+	case c.has("title", "password"):
+		return OnePass, nil
+	case c.has("label", "username", "password"):
+		return Generic, nil
+	case c.has("name", "url", "username", "password"):
+		return Chrome, nil
+	}
+	return "", errors.New("unrecognised CSV header; expected a Chrome, Firefox, Bitwarden, LastPass, 1Password or rook export")
+}
+
+// ReadCSV reads a password export and reports which dialect it was in.
+func ReadCSV(r io.Reader) (Format, []Record, error) {
+	br := bufio.NewReader(r)
+	if bom, err := br.Peek(3); err == nil && string(bom) == "\xef\xbb\xbf" {
+		br.Discard(3)
+	}
+	cr := csv.NewReader(br)
+	cr.FieldsPerRecord = -1
+	cr.LazyQuotes = true
+
+	header, err := cr.Read()
+	if err != nil {
+		return "", nil, errors.New("empty or unreadable CSV file")
+	}
+	c := make(columns, len(header))
+	for i, name := range header {
+		c[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	format, err := detect(c)
+	if err != nil {
+		return "", nil, err
+	}
+	var custom []fieldColumn
+	if format == Generic {
+		custom = fieldColumns(header)
+	}
+
+	var records []Record
+	for {
+		row, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return format, nil, err
+		}
+		if len(row) == 1 && strings.TrimSpace(row[0]) == "" {
+			continue
+		}
+		if r, ok := parseRow(format, c, row); ok {
+			for _, fc := range custom {
+				if fc.index < len(row) && row[fc.index] != "" {
+					r.Fields = append(r.Fields, types.Field{Name: fc.name, Value: row[fc.index], Type: fc.typ})
+				}
+			}
+			records = append(records, r)
+		}
+	}
+	return format, records, nil
+}
+
+func parseRow(format Format, c columns, row []string) (Record, bool) {
+	var r Record
+	switch format {
+	case Bitwarden:
+		var kind types.Kind
+		switch c.get(row, "type") {
+		case "", "login":
+		case "note":
+			kind = types.KindNote
+		default:
+			return r, false
+		}
+		r = Record{
+			Kind:     kind,
+			Username: c.get(row, "login_username"),
+			Password: c.get(row, "login_password"),
+			Notes:    c.get(row, "notes"),
+			Folder:   c.get(row, "folder"),
+			TOTP:     c.get(row, "login_totp"),
+			Fields:   bwFields(c.get(row, "fields")),
+		}
+		if uris := splitURIs(c.get(row, "login_uri")); len(uris) > 0 {
+			r.URL, r.URLs = uris[0], uris[1:]
+		}
+		r.Label = labelFor(c.get(row, "name"), r.URL, r.Username)
+	case Firefox:
+		r = Record{
+			Username: c.get(row, "username"),
+			Password: c.get(row, "password"),
+			URL:      c.get(row, "url"),
+		}
+		r.Label = labelFor("", r.URL, r.Username)
+	case LastPass:
+		r = Record{
+			Username: c.get(row, "username"),
+			Password: c.get(row, "password"),
+			URL:      c.get(row, "url"),
+			Notes:    c.get(row, "extra"),
+			Folder:   c.get(row, "grouping"),
+			TOTP:     c.get(row, "totp"),
+		}
+		// LastPass marks secure notes with this placeholder URL.
+		if r.URL == "http://sn" {
+			r.URL, r.Kind = "", types.KindNote
+		}
+		r.Label = labelFor(c.get(row, "name"), r.URL, r.Username)
+	case OnePass:
+		r = Record{
+			Username: c.get(row, "username"),
+			Password: c.get(row, "password"),
+			URL:      c.get(row, "url", "website", "urls"),
+			Notes:    c.get(row, "notes", "notesplain"),
+			Folder:   c.get(row, "vault", "tags"),
+			TOTP:     c.get(row, "otpauth", "one-time password"),
+		}
+		r.Label = labelFor(c.get(row, "title"), r.URL, r.Username)
+	case Generic:
+		r = Record{
+			Username: c.get(row, "username"),
+			Password: c.get(row, "password"),
+			URL:      c.get(row, "url"),
+			URLs:     strings.Fields(c.get(row, "urls")),
+			Notes:    c.get(row, "notes"),
+			Folder:   c.get(row, "folder"),
+			TOTP:     c.get(row, "totp"),
+		}
+		// An unknown kind is read as a login rather than losing the row.
+		r.Kind, _ = types.ParseKind(c.get(row, "kind"))
+		r.Label = labelFor(c.get(row, "label"), r.URL, r.Username)
+	case Chrome:
+		r = Record{
+			Username: c.get(row, "username"),
+			Password: c.get(row, "password"),
+			URL:      c.get(row, "url"),
+			Notes:    c.get(row, "note"),
+		}
+		r.Label = labelFor(c.get(row, "name"), r.URL, r.Username)
+	}
+	return r, true
+}
+
+// splitURIs splits a comma-separated list of URIs.
+func splitURIs(s string) []string {
+	var uris []string
+	for _, u := range strings.Split(s, ",") {
+		if u = strings.TrimSpace(u); u != "" {
+			uris = append(uris, u)
+		}
+	}
+	return uris
+}
+
+// bwFields parses the fields column of a Bitwarden export, one
+// "name: value" pair per line. Its CSV does not say which were hidden.
+func bwFields(s string) []types.Field {
+	var fields []types.Field
+	for _, line := range strings.Split(s, "\n") {
+		name, value, ok := strings.Cut(line, ": ")
+		if name = strings.TrimSpace(name); ok && name != "" && value != "" {
+			fields = append(fields, types.Field{Name: name, Value: strings.TrimSpace(value), Type: types.FieldText})
+		}
+	}
+	return fields
+}
+
+// genericHeader is the column order of rook's own CSV export. urls holds
+// the further URLs, one per line; custom fields follow in columns of their
+// own, see fieldHeader.
+var genericHeader = []string{"label", "kind", "username", "password", "url", "notes", "folder", "totp", "urls"}
+
+// fieldPrefix starts the header of a custom field column, which reads
+// "field:<type>:<name>" so the type survives a round trip.
+const fieldPrefix = "field:"
+
+func fieldHeader(f types.Field) string {
+	return fieldPrefix + string(f.Type) + ":" + f.Name
+}
+
+type fieldColumn struct {
+	index int
+	name  string
+	typ   types.FieldType
+}
+
+// fieldColumns finds the custom field columns in header.
+func fieldColumns(header []string) []fieldColumn {
+	var cols []fieldColumn
+	for i, h := range header {
+		rest, ok := strings.CutPrefix(strings.TrimSpace(h), fieldPrefix)
+		if !ok {
+			continue
+		}
+		typ, name, ok := strings.Cut(rest, ":")
+		if !ok || name == "" {
+			continue
+		}
+		t, err := types.ParseFieldType(typ)
+		if err != nil {
+			t = types.FieldText
+		}
+		cols = append(cols, fieldColumn{index: i, name: name, typ: t})
+	}
+	return cols
+}
+
+// WriteCSV writes records in rook's generic CSV format, which ReadCSV and
+// most password managers' generic importers accept.
+func WriteCSV(w io.Writer, records []Record) error {
+	header := append([]string(nil), genericHeader...)
+	column := make(map[string]int)
+	for _, r := range records {
+		for _, f := range r.Fields {
+			if h := fieldHeader(f); column[h] == 0 {
+				column[h] = len(header)
+				header = append(header, h)
+			}
+		}
+	}
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	for _, r := range records {
+		row := make([]string, len(header))
+		copy(row, []string{r.Label, string(r.kind()), r.Username, r.Password, r.URL, r.Notes, r.Folder, r.TOTP, strings.Join(r.URLs, "\n")})
+		for _, f := range r.Fields {
+			row[column[fieldHeader(f)]] = f.Value
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
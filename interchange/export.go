@@ -0,0 +1,95 @@
+package interchange
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/mbbgs/rook/types"
+)
+
+// ExportFormat names a file format rook can write.
+type ExportFormat string
+
+const (
+	ExportBitwarden ExportFormat = "bitwarden"
+	ExportCSV       ExportFormat = "csv"
+	ExportKeePass   ExportFormat = "keepass"
+	ExportKDBX      ExportFormat = "kdbx"
+)
+
+// ParseExportFormat accepts the format names used on the command line.
+func ParseExportFormat(s string) (ExportFormat, error) {
+	switch f := ExportFormat(strings.ToLower(strings.TrimSpace(s))); f {
+	case ExportBitwarden, ExportCSV, ExportKeePass, ExportKDBX:
+		return f, nil
+	case "json":
+		return ExportBitwarden, nil
+	case "xml":
+		return ExportKeePass, nil
+	}
+	return "", fmt.Errorf("unknown export format %q (want bitwarden, csv, keepass or kdbx)", s)
+}
+
+// Extension is the usual file extension for f.
+func (f ExportFormat) Extension() string {
+	switch f {
+	case ExportBitwarden:
+		return ".json"
+	case ExportKeePass:
+		return ".xml"
+	case ExportKDBX:
+		return ".kdbx"
+	}
+	return ".csv"
+}
+
+// Records converts vault entries to records, sorted by label.
+func Records(entries map[string]types.Data) []Record {
+	records := make([]Record, 0, len(entries))
+	for label, data := range entries {
+		records = append(records, FromData(label, data))
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Label < records[j].Label })
+	return records
+}
+
+// Plaintext reports whether f leaves passwords readable to anyone with the
+// file.
+func (f ExportFormat) Plaintext() bool {
+	return f != ExportKDBX
+}
+
+// Export writes records to w in format f. password locks formats that are
+// encrypted themselves and is ignored by the others.
+func Export(w io.Writer, f ExportFormat, records []Record, password string) error {
+	switch f {
+	case ExportBitwarden:
+		return WriteBitwarden(w, records)
+	case ExportKeePass:
+		return WriteKeePassXML(w, records)
+	case ExportCSV:
+		return WriteCSV(w, records)
+	case ExportKDBX:
+		return WriteKDBX(w, records, password)
+	}
+	return fmt.Errorf("unknown export format %q", f)
+}
+
+// randomUUID returns 16 random bytes laid out as a version 4 UUID.
+func randomUUID() []byte {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return b
+}
+
+func newUUID() string {
+	b := randomUUID()
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
@@ -0,0 +1,183 @@
+// Package interchange converts between rook entries and the files other
+// password managers import and export.
+package interchange
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/mbbgs/rook/types"
+)
+
+// Record is one credential as read from, or written to, a foreign format.
+// It carries more than types.Data so nothing a source offers is dropped
+// before it has to be.
+type Record struct {
+	Label    string
+	Kind     types.Kind // empty for a login
+	Username string
+	Password string
+	URL      string
+	URLs     []string // further sites besides URL
+	Notes    string
+	Folder   string
+	Tags     []string
+	TOTP     string
+	Fields   []types.Field
+}
+
+// Data maps r onto a rook entry.
+func (r Record) Data() types.Data {
+	link := r.URL
+	if link == "" {
+		link = "(Not Set)"
+	}
+	return types.Data{
+		Kind:      r.Kind,
+		Lname:     r.Username,
+		Lpassword: []byte(r.Password),
+		Lurl:      link,
+		Notes:     r.Notes,
+		Folder:    types.CleanFolder(r.Folder),
+		Tags:      r.Tags,
+		TOTP:      r.TOTP,
+		URLs:      r.URLs,
+		Fields:    r.Fields,
+	}
+}
+
+// FromData maps a rook entry onto a record.
+func FromData(label string, data types.Data) Record {
+	link := data.Lurl
+	if link == "(Not Set)" {
+		link = ""
+	}
+	return Record{
+		Label:    label,
+		Kind:     data.Kind,
+		Username: data.Lname,
+		Password: string(data.Lpassword),
+		URL:      link,
+		URLs:     data.URLs,
+		Notes:    data.Notes,
+		Folder:   data.Folder,
+		Tags:     data.Tags,
+		TOTP:     data.TOTP,
+		Fields:   data.Fields,
+	}
+}
+
+// kind is the record's kind, defaulting to login.
+func (r Record) kind() types.Kind {
+	return types.Data{Kind: r.Kind}.EntryKind()
+}
+
+// labelFor picks a label for a record that has no name of its own.
+func labelFor(name, link, username string) string {
+	if name = strings.TrimSpace(name); name != "" {
+		return name
+	}
+	if u, err := url.Parse(strings.TrimSpace(link)); err == nil && u.Hostname() != "" {
+		return strings.TrimPrefix(u.Hostname(), "www.")
+	}
+	if username != "" {
+		return username
+	}
+	return "imported"
+}
+
+// Collision says what to do with a record whose label is already taken.
+type Collision string
+
+const (
+	Skip      Collision = "skip"
+	Rename    Collision = "rename"
+	Overwrite Collision = "overwrite"
+)
+
+// ParseCollision accepts the strategy names used on the command line.
+func ParseCollision(s string) (Collision, error) {
+	switch c := Collision(strings.ToLower(strings.TrimSpace(s))); c {
+	case Skip, Rename, Overwrite:
+		return c, nil
+	}
+	return "", fmt.Errorf("unknown collision strategy %q (want skip, rename or overwrite)", s)
+}
+
+// Op is what an import does with one record.
+type Op string
+
+const (
+	OpAdd       Op = "add"
+	OpRename    Op = "rename"
+	OpOverwrite Op = "overwrite"
+	OpSkip      Op = "skip"
+)
+
+// Action is one planned step of an import.
+type Action struct {
+	Op     Op
+	Label  string // label the record is stored under
+	Record Record
+	Reason string // why a record is skipped or renamed
+}
+
+// Plan decides, without writing anything, where each record goes. existing
+// holds the labels already in the vault; records that collide with it or
+// with an earlier record in the same file are handled per strategy.
+// Records are skipped when their kind needs a password and they have none,
+// or when they lack what their kind's schema requires.
+func Plan(records []Record, existing map[string]bool, strategy Collision) []Action {
+	taken := make(map[string]bool, len(existing)+len(records))
+	for label := range existing {
+		taken[label] = true
+	}
+	planned := make(map[string]bool, len(records))
+
+	actions := make([]Action, 0, len(records))
+	for _, r := range records {
+		a := Action{Op: OpAdd, Label: r.Label, Record: r}
+		invalid := r.Data().ValidateKind()
+		switch {
+		case r.kind().HasPassword() && r.Password == "":
+			a.Op, a.Reason = OpSkip, "no password"
+		case invalid != nil:
+			a.Op, a.Reason = OpSkip, invalid.Error()
+		case !taken[r.Label]:
+		case strategy == Overwrite && !planned[r.Label]:
+			a.Op = OpOverwrite
+		case strategy == Skip:
+			a.Op, a.Reason = OpSkip, "label exists"
+		default:
+			// Overwriting a record added earlier in the same file would
+			// lose it silently, so those are renamed too.
+			a.Op, a.Label = OpRename, freeLabel(r.Label, taken)
+			a.Reason = "label " + r.Label + " exists"
+		}
+		if a.Op != OpSkip {
+			taken[a.Label] = true
+			planned[a.Label] = true
+		}
+		actions = append(actions, a)
+	}
+	return actions
+}
+
+func freeLabel(label string, taken map[string]bool) string {
+	for n := 2; ; n++ {
+		candidate := fmt.Sprintf("%s (%d)", label, n)
+		if !taken[candidate] {
+			return candidate
+		}
+	}
+}
+
+// Summary counts planned actions by op.
+func Summary(actions []Action) map[Op]int {
+	counts := make(map[Op]int)
+	for _, a := range actions {
+		counts[a.Op]++
+	}
+	return counts
+}
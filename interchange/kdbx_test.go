@@ -0,0 +1,203 @@
+package interchange
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/mbbgs/rook/types"
+)
+
+var kdbxRecords = []Record{
+	{
+		Label: "github", Username: "bob@example.com", Password: "hunter2",
+		URL: "https://github.com", URLs: []string{"https://gist.github.com"},
+		Notes: "two\nlines & <markup>", Folder: "work/dev", Tags: []string{"code", "2fa"},
+		TOTP: "otpauth://totp/GitHub:bob?secret=JBSWY3DPEHPK3PXP",
+		Fields: []types.Field{
+			{Name: "Recovery codes", Value: "1111 2222", Type: types.FieldHidden},
+			{Name: "Security question", Value: "first pet", Type: types.FieldText},
+		},
+	},
+	{Label: "bank ünïcödé", Username: "bob", Password: "pässwörd🔑", Folder: "personal"},
+	{Label: "root entry", Username: "root", Password: "toor"},
+	{
+		Label: "visa", Kind: types.KindCard, Folder: "personal",
+		Fields: []types.Field{
+			{Name: "Cardholder", Value: "Bob Example", Type: types.FieldText},
+			{Name: "Number", Value: "4111111111111111", Type: types.FieldHidden},
+		},
+	},
+	{Label: "wifi", Kind: types.KindNote, Notes: "password is on the router"},
+}
+
+// normalize sorts records by label and drops the differences KeePass
+// cannot express, so they compare equal after a round trip.
+func normalize(records []Record) []Record {
+	out := slices.Clone(records)
+	for i := range out {
+		if out[i].Kind == types.KindLogin {
+			out[i].Kind = ""
+		}
+		if len(out[i].Tags) == 0 {
+			out[i].Tags = nil
+		}
+	}
+	slices.SortFunc(out, func(a, b Record) int { return strings.Compare(a.Label, b.Label) })
+	return out
+}
+
+func writeKDBX(t *testing.T, records []Record, password string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := WriteKDBX(&buf, records, password); err != nil {
+		t.Fatal(err)
+	}
+	if !IsKDBX(buf.Bytes()) {
+		t.Fatal("written database does not look like KDBX")
+	}
+	return buf.Bytes()
+}
+
+func TestKDBXRoundTrip(t *testing.T) {
+	tests := []struct {
+		name     string
+		records  []Record
+		password string
+	}{
+		{"entries", kdbxRecords, "correct horse"},
+		{"empty database", nil, "pw"},
+		{"empty password", kdbxRecords[:1], ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := writeKDBX(t, tt.records, tt.password)
+			got, err := ReadKDBX(bytes.NewReader(db), tt.password)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := normalize(tt.records); !reflect.DeepEqual(normalize(got), want) {
+				t.Fatalf("read back\n%+v\nwant\n%+v", normalize(got), want)
+			}
+		})
+	}
+}
+
+func TestKDBXRejects(t *testing.T) {
+	db := writeKDBX(t, kdbxRecords[:1], "right")
+	flip := func(i int) []byte {
+		out := bytes.Clone(db)
+		out[i] ^= 1
+		return out
+	}
+	tests := []struct {
+		name     string
+		data     []byte
+		password string
+		want     error
+	}{
+		{"wrong password", db, "wrong", ErrKDBXPassword},
+		{"empty password", db, "", ErrKDBXPassword},
+		{"header", flip(20), "right", nil},
+		{"payload", flip(len(db) - 40), "right", nil},
+		{"truncated", db[:len(db)-10], "right", nil},
+		{"not kdbx", []byte("label,username,password\n"), "right", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records, err := ReadKDBX(bytes.NewReader(tt.data), tt.password)
+			if err == nil {
+				t.Fatalf("ReadKDBX accepted the database: %+v", records)
+			}
+			if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+// Costs are read from the header before it is authenticated; ones beyond
+// the limits must be refused before any work is done.
+func TestKDBXCostLimits(t *testing.T) {
+	salt := bytes.Repeat([]byte{1}, 32)
+	argon := func(memory, iterations uint64) []byte {
+		var w variantWriter
+		w.bytes("$UUID", uuidArgon2d)
+		w.uint32("V", 0x13)
+		w.bytes("S", salt)
+		w.uint32("P", 2)
+		w.uint64("M", memory)
+		w.uint64("I", iterations)
+		return w.encode()
+	}
+	aesKDF := func(rounds uint64) []byte {
+		var w variantWriter
+		w.bytes("$UUID", uuidAESKDF)
+		w.bytes("S", salt)
+		w.uint64("R", rounds)
+		return w.encode()
+	}
+	tests := []struct {
+		name   string
+		params []byte
+	}{
+		{"argon2 memory", argon(kdbxMaxArgonMemory+1<<20, 1)},
+		{"argon2 work", argon(1<<30, kdbxMaxArgonWork>>30+1)},
+		{"argon2 iterations", argon(64<<20, 1<<40)},
+		{"argon2 no iterations", argon(64<<20, 0)},
+		{"argon2 memory below lanes", argon(1<<10, 1)},
+		{"aes rounds", aesKDF(kdbxMaxAESRounds + 1)},
+		{"aes rounds overflow", aesKDF(1 << 63)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := transformKey(compositeKey("pw"), tt.params); err == nil {
+				t.Fatal("transformKey accepted the costs")
+			}
+		})
+	}
+}
+
+// Databases saved by KeePassXC, with the password each was saved with and
+// the entries it holds. They are kept in testdata so that reading files
+// rook did not write itself stays covered.
+func TestKDBXFixtures(t *testing.T) {
+	tests := []struct {
+		file     string
+		password string
+		labels   []string
+	}{
+		{"keepassxc.kdbx", "rook", []string{"github", "personal bank"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join("testdata", tt.file))
+			if errors.Is(err, os.ErrNotExist) {
+				t.Skipf("testdata/%s is missing; save a KDBX 4 database from KeePassXC there", tt.file)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			records, err := ReadKDBX(bytes.NewReader(data), tt.password)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var labels []string
+			for _, r := range records {
+				labels = append(labels, r.Label)
+			}
+			slices.Sort(labels)
+			if !slices.Equal(labels, tt.labels) {
+				t.Fatalf("labels %q, want %q", labels, tt.labels)
+			}
+			if _, err := ReadKDBX(bytes.NewReader(data), tt.password+"x"); !errors.Is(err, ErrKDBXPassword) {
+				t.Fatalf("wrong password gave %v, want ErrKDBXPassword", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,243 @@
+package interchange
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/mbbgs/rook/types"
+)
+
+// KeePass 2.x XML, the format of KeePass' "KeePass XML (2.x)" export and
+// the payload of a KDBX database.
+type kpFile struct {
+	XMLName xml.Name `xml:"KeePassFile"`
+	Meta    kpMeta   `xml:"Meta"`
+	Root    kpRoot   `xml:"Root"`
+}
+
+type kpMeta struct {
+	Generator         string `xml:"Generator"`
+	DatabaseName      string `xml:"DatabaseName"`
+	RecycleBinEnabled string `xml:"RecycleBinEnabled,omitempty"`
+	RecycleBinUUID    string `xml:"RecycleBinUUID,omitempty"`
+}
+
+type kpRoot struct {
+	Group kpGroup `xml:"Group"`
+}
+
+type kpGroup struct {
+	UUID    string    `xml:"UUID"`
+	Name    string    `xml:"Name"`
+	Entries []kpEntry `xml:"Entry"`
+	Groups  []kpGroup `xml:"Group"`
+}
+
+type kpEntry struct {
+	UUID    string     `xml:"UUID"`
+	Tags    string     `xml:"Tags,omitempty"`
+	Times   kpTimes    `xml:"Times"`
+	Strings []kpString `xml:"String"`
+}
+
+type kpTimes struct {
+	CreationTime         string `xml:"CreationTime"`
+	LastModificationTime string `xml:"LastModificationTime"`
+}
+
+type kpString struct {
+	Key   string  `xml:"Key"`
+	Value kpValue `xml:"Value"`
+}
+
+type kpValue struct {
+	Protected string `xml:"Protected,attr,omitempty"`
+	Memory    string `xml:"ProtectInMemory,attr,omitempty"`
+	Text      string `xml:",chardata"`
+}
+
+// Standard KeePass string field names.
+const (
+	kpTitle    = "Title"
+	kpUserName = "UserName"
+	kpPassword = "Password"
+	kpURL      = "URL"
+	kpNotes    = "Notes"
+	kpOTP      = "otp"
+	// kpExtraURL prefixes further URLs, as KeePass2Android and KeePassXC
+	// write them.
+	kpExtraURL = "KP2A_URL"
+	// kpKind holds the kind of entries other than logins, whose fields
+	// are written as custom strings.
+	kpKind = "rook kind"
+)
+
+// kpStandard reports whether key is one of the fields a record maps onto,
+// so a custom field of that name would be overwritten by it.
+func kpStandard(key string) bool {
+	switch key {
+	case kpTitle, kpUserName, kpPassword, kpURL, kpNotes, kpOTP, kpKind:
+		return true
+	}
+	return strings.HasPrefix(key, kpExtraURL)
+}
+
+// kpTree builds the group tree for records. Folders become groups below
+// the root; a "/" in a folder name nests them. stamp formats times, which
+// the XML export and KDBX 4 write differently.
+func kpTree(records []Record, stamp func(time.Time) string) kpFile {
+	now := stamp(time.Now().UTC())
+	root := kpGroup{UUID: kpUUID(), Name: "rook"}
+
+	for _, r := range records {
+		group := &root
+		if r.Folder != "" {
+			for _, name := range strings.Split(r.Folder, "/") {
+				group = group.child(name)
+			}
+		}
+		entry := kpEntry{
+			UUID:  kpUUID(),
+			Tags:  strings.Join(r.Tags, ";"),
+			Times: kpTimes{CreationTime: now, LastModificationTime: now},
+			Strings: []kpString{
+				{Key: kpTitle, Value: kpValue{Text: r.Label}},
+				{Key: kpUserName, Value: kpValue{Text: r.Username}},
+				{Key: kpPassword, Value: kpValue{Text: r.Password, Memory: "True"}},
+				{Key: kpURL, Value: kpValue{Text: r.URL}},
+				{Key: kpNotes, Value: kpValue{Text: r.Notes}},
+			},
+		}
+		if r.TOTP != "" {
+			entry.Strings = append(entry.Strings, kpString{Key: kpOTP, Value: kpValue{Text: r.TOTP, Memory: "True"}})
+		}
+		if kind := r.kind(); kind != types.KindLogin {
+			entry.Strings = append(entry.Strings, kpString{Key: kpKind, Value: kpValue{Text: string(kind)}})
+		}
+		for i, u := range r.URLs {
+			entry.Strings = append(entry.Strings, kpString{Key: fmt.Sprintf("%s_%d", kpExtraURL, i+1), Value: kpValue{Text: u}})
+		}
+		for _, f := range r.Fields {
+			key := f.Name
+			if kpStandard(key) {
+				key += " (rook)"
+			}
+			v := kpValue{Text: f.Value}
+			if f.Type == types.FieldHidden {
+				v.Memory = "True"
+			}
+			entry.Strings = append(entry.Strings, kpString{Key: key, Value: v})
+		}
+		group.Entries = append(group.Entries, entry)
+	}
+
+	return kpFile{
+		Meta: kpMeta{Generator: "rook", DatabaseName: "rook"},
+		Root: kpRoot{Group: root},
+	}
+}
+
+func (g *kpGroup) child(name string) *kpGroup {
+	for i := range g.Groups {
+		if g.Groups[i].Name == name {
+			return &g.Groups[i]
+		}
+	}
+	g.Groups = append(g.Groups, kpGroup{UUID: kpUUID(), Name: name})
+	return &g.Groups[len(g.Groups)-1]
+}
+
+// WriteKeePassXML writes records as a KeePass 2.x XML export.
+func WriteKeePassXML(w io.Writer, records []Record) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "\t")
+	tree := kpTree(records, func(t time.Time) string { return t.Format(time.RFC3339) })
+	if err := enc.Encode(tree); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// kpUUID returns a random UUID in KeePass' base64 form.
+func kpUUID() string {
+	return base64.StdEncoding.EncodeToString(randomUUID())
+}
+
+// kpRecords flattens a group tree into records, with the path of group
+// names below the root as the folder. The recycle bin is left out.
+func kpRecords(f *kpFile) []Record {
+	var records []Record
+	var walk func(g *kpGroup, path string)
+	walk = func(g *kpGroup, path string) {
+		if f.Meta.RecycleBinEnabled != "False" && f.Meta.RecycleBinUUID != "" && g.UUID == f.Meta.RecycleBinUUID {
+			return
+		}
+		for _, e := range g.Entries {
+			r := Record{Folder: path}
+			for _, s := range e.Strings {
+				switch s.Key {
+				case kpTitle:
+					r.Label = s.Value.Text
+				case kpUserName:
+					r.Username = s.Value.Text
+				case kpPassword:
+					r.Password = s.Value.Text
+				case kpURL:
+					r.URL = s.Value.Text
+				case kpNotes:
+					r.Notes = s.Value.Text
+				case kpOTP:
+					r.TOTP = s.Value.Text
+				case kpKind:
+					r.Kind, _ = types.ParseKind(s.Value.Text)
+				default:
+					if s.Value.Text == "" {
+						continue
+					}
+					if strings.HasPrefix(s.Key, kpExtraURL) {
+						r.URLs = append(r.URLs, s.Value.Text)
+						continue
+					}
+					f := types.Field{Name: s.Key, Value: s.Value.Text, Type: types.FieldText}
+					if name := strings.TrimSuffix(s.Key, " (rook)"); kpStandard(name) {
+						f.Name = name
+					}
+					if s.Value.Memory == "True" {
+						f.Type = types.FieldHidden
+					}
+					r.Fields = append(r.Fields, f)
+				}
+			}
+			r.Label = labelFor(r.Label, r.URL, r.Username)
+			r.Tags = splitTags(e.Tags)
+			records = append(records, r)
+		}
+		for i := range g.Groups {
+			sub := g.Groups[i].Name
+			if path != "" {
+				sub = path + "/" + sub
+			}
+			walk(&g.Groups[i], sub)
+		}
+	}
+	walk(&f.Root.Group, "")
+	return records
+}
+
+func splitTags(s string) []string {
+	var tags []string
+	for _, tag := range strings.FieldsFunc(s, func(r rune) bool { return r == ';' || r == ',' }) {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
@@ -0,0 +1,189 @@
+// Package keyspace lays out the keys of the badger store. Every key starts
+// with a one-byte namespace tag followed by its components, each escaped
+// and terminated, so no component can run into the next one, keys of one
+// namespace never match a prefix of another, and keys sort in the order of
+// their components.
+package keyspace
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Space is a key namespace.
+type Space byte
+
+// The tags are control bytes so that no key written before namespaces
+// existed, all of which are printable, decodes as one.
+const (
+	User  Space = 0x01 // the user record
+	Meta  Space = 0x02 // manifest, schema version and other bookkeeping
+	Entry Space = 0x03 // live entries, by owner and label
+	Trash Space = 0x04 // trashed entries, by owner and deletion
+	Audit Space = 0x05 // audit events, by owner and time
+	Index Space = 0x06 // secondary indexes, by owner, term and entry
+)
+
+// arity is how many components each namespace's keys have.
+var arity = map[Space]int{User: 1, Meta: 1, Entry: 2, Trash: 2, Audit: 2, Index: 3}
+
+func (s Space) String() string {
+	switch s {
+	case User:
+		return "user"
+	case Meta:
+		return "meta"
+	case Entry:
+		return "entry"
+	case Trash:
+		return "trash"
+	case Audit:
+		return "audit"
+	case Index:
+		return "index"
+	}
+	return fmt.Sprintf("space(%#x)", byte(s))
+}
+
+// Components are terminated by term. Inside a component, term and esc are
+// written as esc followed by one byte, which keeps the byte order of the
+// unescaped components.
+const (
+	term = 0x00
+	esc  = 0x01
+)
+
+// ErrMalformed is returned by Decode for bytes that are not a key.
+var ErrMalformed = errors.New("keyspace: malformed key")
+
+// Key is a decoded key.
+type Key struct {
+	Space Space
+	Parts []string
+}
+
+// Encode lays k out as bytes.
+func (k Key) Encode() []byte {
+	return appendParts([]byte{byte(k.Space)}, k.Parts)
+}
+
+func (k Key) String() string {
+	return k.Space.String() + "/" + strings.Join(k.Parts, "/")
+}
+
+// Decode parses a key written by Encode, checking it has as many
+// components as its namespace uses.
+func Decode(b []byte) (Key, error) {
+	if len(b) == 0 {
+		return Key{}, ErrMalformed
+	}
+	k := Key{Space: Space(b[0])}
+	want, ok := arity[k.Space]
+	if !ok {
+		return Key{}, fmt.Errorf("%w: unknown namespace %#x", ErrMalformed, b[0])
+	}
+	var part []byte
+	for i := 1; i < len(b); i++ {
+		switch c := b[i]; c {
+		case term:
+			k.Parts = append(k.Parts, string(part))
+			part = part[:0]
+		case esc:
+			if i++; i == len(b) || (b[i] != term+1 && b[i] != esc+1) {
+				return Key{}, fmt.Errorf("%w: bad escape", ErrMalformed)
+			}
+			part = append(part, b[i]-1)
+		default:
+			part = append(part, c)
+		}
+	}
+	if len(part) > 0 {
+		return Key{}, fmt.Errorf("%w: unterminated component", ErrMalformed)
+	}
+	if len(k.Parts) != want {
+		return Key{}, fmt.Errorf("%w: %s keys have %d components, got %d", ErrMalformed, k.Space, want, len(k.Parts))
+	}
+	return k, nil
+}
+
+// In reports whether b is a key of namespace s.
+func In(b []byte, s Space) bool {
+	return len(b) > 0 && Space(b[0]) == s
+}
+
+// Prefix is the prefix shared by every key of space whose leading
+// components are parts.
+func Prefix(space Space, parts ...string) []byte {
+	return appendParts([]byte{byte(space)}, parts)
+}
+
+func appendParts(b []byte, parts []string) []byte {
+	for _, p := range parts {
+		for i := 0; i < len(p); i++ {
+			switch c := p[i]; c {
+			case term, esc:
+				b = append(b, esc, c+1)
+			default:
+				b = append(b, c)
+			}
+		}
+		b = append(b, term)
+	}
+	return b
+}
+
+// UserKey is where the user record is kept.
+func UserKey() []byte {
+	return Key{User, []string{"record"}}.Encode()
+}
+
+// MetaKey is where the bookkeeping record name is kept.
+func MetaKey(name string) []byte {
+	return Key{Meta, []string{name}}.Encode()
+}
+
+// EntryKey is where the entry label of owner is kept.
+func EntryKey(owner, label string) []byte {
+	return Key{Entry, []string{owner, label}}.Encode()
+}
+
+// EntryPrefix is the prefix of every entry of owner.
+func EntryPrefix(owner string) []byte {
+	return Prefix(Entry, owner)
+}
+
+// TrashKey is where a trashed entry of owner is kept; id tells apart
+// entries trashed under the same label.
+func TrashKey(owner, id string) []byte {
+	return Key{Trash, []string{owner, id}}.Encode()
+}
+
+// TrashPrefix is the prefix of every trashed entry of owner.
+func TrashPrefix(owner string) []byte {
+	return Prefix(Trash, owner)
+}
+
+// AuditKey is where an audit event of owner recorded at t is kept. Keys
+// sort by time.
+func AuditKey(owner string, t time.Time) []byte {
+	return Key{Audit, []string{owner, fmt.Sprintf("%020d", t.UnixNano())}}.Encode()
+}
+
+// AuditPrefix is the prefix of every audit event of owner.
+func AuditPrefix(owner string) []byte {
+	return Prefix(Audit, owner)
+}
+
+// IndexKey records that the entry label of owner has term, such as a tag.
+// Index keys have no value; the entry is found from its key.
+func IndexKey(owner, term, label string) []byte {
+	return Key{Index, []string{owner, term, label}}.Encode()
+}
+
+// IndexPrefix is the prefix of every index key of owner for term; with no
+// term, of every index key of owner.
+func IndexPrefix(owner string, term ...string) []byte {
+	return Prefix(Index, append([]string{owner}, term...)...)
+}
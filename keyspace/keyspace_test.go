@@ -0,0 +1,121 @@
+package keyspace
+
+import (
+	"bytes"
+	"errors"
+	"slices"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		key  Key
+	}{
+		{"plain", Key{Entry, []string{"bob", "github"}}},
+		{"spaces", Key{Entry, []string{"bob", "My Bank (2)"}}},
+		{"terminator", Key{Entry, []string{"bob", "a\x00b"}}},
+		{"escape", Key{Entry, []string{"bob", "a\x01b"}}},
+		{"escaped escape", Key{Entry, []string{"bob", "\x01\x02\x00\x01"}}},
+		{"namespace bytes", Key{Entry, []string{"\x03\x04", "\x06label\x02"}}},
+		{"empty parts", Key{Entry, []string{"", ""}}},
+		{"unicode", Key{Trash, []string{"bøb", "パスワード"}}},
+		{"user", Key{User, []string{"record"}}},
+		{"meta", Key{Meta, []string{"manifest"}}},
+		{"index", Key{Index, []string{"bob", "tag:work\x00", "label"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := tt.key.Encode()
+			got, err := Decode(b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Space != tt.key.Space || !slices.Equal(got.Parts, tt.key.Parts) {
+				t.Fatalf("decoded %q, want %q", got, tt.key)
+			}
+			if !In(b, tt.key.Space) {
+				t.Fatalf("In(%x, %s) is false", b, tt.key.Space)
+			}
+		})
+	}
+}
+
+func TestDecodeMalformed(t *testing.T) {
+	tests := []struct {
+		name string
+		b    []byte
+	}{
+		{"empty", nil},
+		{"unknown namespace", []byte{0x7f, 'a', term}},
+		{"legacy key", []byte("bob:github")},
+		{"unterminated", []byte{byte(Entry), 'b', term, 'x'}},
+		{"too few parts", []byte{byte(Entry), 'b', term}},
+		{"too many parts", []byte{byte(User), 'a', term, 'b', term}},
+		{"dangling escape", []byte{byte(Meta), 'a', esc}},
+		{"bad escape", []byte{byte(Meta), 'a', esc, 'z', term}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if k, err := Decode(tt.b); !errors.Is(err, ErrMalformed) {
+				t.Fatalf("Decode(%x) = %q, %v; want ErrMalformed", tt.b, k, err)
+			}
+		})
+	}
+}
+
+// Components must not run into each other: an owner that ends where
+// another owner's label begins still gets keys of its own.
+func TestPrefixIsolation(t *testing.T) {
+	tests := []struct {
+		name   string
+		key    []byte
+		prefix []byte
+	}{
+		{"owner prefix of owner", EntryKey("bobby", "x"), EntryPrefix("bob")},
+		{"owner with terminator", EntryKey("bob\x00x", "y"), EntryPrefix("bob")},
+		{"entry vs trash", TrashKey("bob", "x"), EntryPrefix("bob")},
+		{"term vs term", IndexKey("bob", "tag:workshop", "x"), IndexPrefix("bob", "tag:work")},
+		{"other owner index", IndexKey("eve", "tag:a", "x"), IndexPrefix("bob")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if bytes.HasPrefix(tt.key, tt.prefix) {
+				t.Fatalf("%x has prefix %x", tt.key, tt.prefix)
+			}
+		})
+	}
+	if !bytes.HasPrefix(EntryKey("bob", "x"), EntryPrefix("bob")) {
+		t.Fatal("entry key lacks its owner prefix")
+	}
+	if !bytes.HasPrefix(IndexKey("bob", "tag:work", "x"), IndexPrefix("bob", "tag:work")) {
+		t.Fatal("index key lacks its term prefix")
+	}
+}
+
+// Encoded keys sort in the order of their components, escapes included.
+func TestOrder(t *testing.T) {
+	labels := []string{"", "\x00", "\x00\x00", "\x01", "\x02", "a", "a\x00", "a\x01", "ab", "b"}
+	keys := make([][]byte, len(labels))
+	for i, l := range labels {
+		keys[len(labels)-1-i] = EntryKey("bob", l)
+	}
+	sort.Slice(keys, func(i, j int) bool { return bytes.Compare(keys[i], keys[j]) < 0 })
+	for i, k := range keys {
+		got, err := Decode(k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Parts[1] != labels[i] {
+			t.Fatalf("position %d holds %q, want %q", i, got.Parts[1], labels[i])
+		}
+	}
+
+	early := AuditKey("bob", time.Unix(1, 0))
+	late := AuditKey("bob", time.Unix(1e9, 0))
+	if bytes.Compare(early, late) >= 0 {
+		t.Fatal("audit keys do not sort by time")
+	}
+}
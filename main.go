@@ -4,6 +4,8 @@ import (
 	"flag"
 	"os"
 	"fmt"
+	"strings"
+	"github.com/mbbgs/rook/breach"
 	"github.com/mbbgs/rook/utils"
 	"github.com/mbbgs/rook/consts"
 	"github.com/mbbgs/rook/hooks"
@@ -20,14 +22,59 @@ func main() {
 	// Parse command line flags
 	reset := flag.Bool("reset", false, "Reset your password")
 	drop := flag.Bool("drop", false, "Permanently delete your encrypted storage")
+	recoverVault := flag.Bool("recover", false, "Unlock with your master key and set a new password")
+	rekey := flag.Bool("rekey", false, "Rotate the vault encryption key")
+	backupFile := flag.String("backup", "", "Write an encrypted backup of the vault to `file`")
+	restoreFile := flag.String("restore", "", "Replace the vault with an encrypted backup from `file`")
+	hibp := flag.String("hibp", "", "Check passwords against a local Have I Been Pwned `path`: the hash-ordered file or a directory of range files (none to stop)")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: rook [flags]\n       rook search <query>\n\nFlags:\n")
+		flag.PrintDefaults()
+	}
 	flag.Parse()
 
+	if *hibp != "" {
+		if err := breach.SavePath(*hibp); err != nil {
+			utils.ErrorE(err)
+			return
+		}
+		if *hibp == "none" {
+			utils.Done("Breach checks disabled.")
+		} else {
+			utils.Done("Breach checks will use " + *hibp)
+		}
+	}
+
 	// Handle command line options
 switch {
+	case flag.Arg(0) == "search":
+		query := strings.Join(flag.Args()[1:], " ")
+		if strings.TrimSpace(query) == "" {
+			flag.Usage()
+			os.Exit(2)
+		}
+		hooks.Event.Emit(consts.SEARCH_VAULT, query)
+		return
 	case *reset:
 		hooks.Event.Emit(consts.RESET_PASSWORD, nil)
 		waitForExit()
 		return
+	case *recoverVault:
+		hooks.Event.Emit(consts.RECOVER_VAULT, nil)
+		waitForExit()
+		return
+	case *rekey:
+		hooks.Event.Emit(consts.REKEY_VAULT, nil)
+		waitForExit()
+		return
+	case *backupFile != "":
+		hooks.Event.Emit(consts.BACKUP_VAULT, *backupFile)
+		waitForExit()
+		return
+	case *restoreFile != "":
+		hooks.Event.Emit(consts.RESTORE_VAULT, *restoreFile)
+		waitForExit()
+		return
 	case *drop:
 		hooks.Event.Emit(consts.DROP_TABLE, nil)
 		waitForExit()
@@ -2,7 +2,8 @@ package models
 
 import (
 	"time"
-	"bytes"
+
+	"github.com/mbbgs/rook/securecrypto"
 )
 
 type Label string
@@ -10,9 +11,29 @@ type Label string
 type User struct {
 	Username  string    `json:"username"`
 	Password  []byte
+	PasswordKDF securecrypto.KDFParams `json:"password_kdf"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
-	masterKey []byte
+	// WrappedKey and MasterWrappedKey hold the vault data key sealed under
+	// keys derived from the login password and the master key.
+	WrappedKey       []byte `json:"wrapped_key,omitempty"`
+	MasterWrappedKey []byte `json:"master_wrapped_key,omitempty"`
+	// KDF and KeySalt describe the password-derived entry key of vaults
+	// created before data keys; they are cleared once the vault is migrated.
+	KDF       securecrypto.KDFParams `json:"kdf"`
+	KeySalt   []byte    `json:"key_salt,omitempty"`
+	// MasterKey is the "hash:salt" of the recovery master key.
+	MasterKey []byte    `json:"master_key"`
+	MasterKDF securecrypto.KDFParams `json:"master_kdf"`
+	// HistoryLimit is how many earlier passwords each entry keeps: zero
+	// means the default, negative keeps none.
+	HistoryLimit int `json:"history_limit,omitempty"`
+	// TrashRetentionDays is how long removed entries stay in the trash:
+	// zero means the default, negative keeps them until emptied.
+	TrashRetentionDays int `json:"trash_retention_days,omitempty"`
+	// ClipboardClearSeconds is how long copied values stay on the
+	// clipboard: zero means the default, negative leaves them there.
+	ClipboardClearSeconds int `json:"clipboard_clear_seconds,omitempty"`
 }
 
 func NewUser(username, password, masterKey string) *User {
@@ -22,7 +43,7 @@ func NewUser(username, password, masterKey string) *User {
 		Password:  []byte(password),
 		CreatedAt: now,
 		UpdatedAt: now,
-		masterKey: []byte(masterKey),
+		MasterKey: []byte(masterKey),
 	}
 }
 
@@ -30,7 +51,8 @@ func FromUser(user *User) User {
 	return *user
 }
 
-func (user *User) IsMaster(computedMasterHash string) bool {
-	return bytes.Equal([]byte(user.masterKey),[]byte(computedMasterHash))
+// IsMaster reports whether masterKey matches the stored master key hash.
+func (user *User) IsMaster(masterKey string) bool {
+	return securecrypto.VerifySecret(masterKey, user.MasterKey, user.MasterKDF)
 }
 
@@ -0,0 +1,256 @@
+// Package search ranks entries against a query by fuzzy subsequence
+// matching, the way fuzzy finders do: every character of a query term must
+// appear in order, and matches that are contiguous or start words score
+// higher.
+package search
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/mbbgs/rook/types"
+)
+
+// Scores, after fzf: a match earns scoreMatch per character plus bonuses,
+// and loses for every character skipped between two matches.
+const (
+	scoreMatch       = 16
+	bonusBoundary    = 8 // first character of a word
+	bonusFirst       = 8 // first character of the text
+	bonusConsecutive = 6 // follows the previous match directly
+	bonusCase        = 1 // same case as typed
+	penaltyGapStart  = 3
+	penaltyGapExtend = 1
+)
+
+// Match scores pattern against text. It reports false if pattern is not a
+// subsequence of text, ignoring case. positions are the byte offsets of
+// the matched characters in text.
+func Match(pattern, text string) (score int, positions []int, ok bool) {
+	p := []rune(strings.ToLower(pattern))
+	if len(p) == 0 {
+		return 0, nil, true
+	}
+
+	// Offsets and runes of text, with the bonus each position would get.
+	type char struct {
+		off   int
+		r     rune
+		bonus int
+	}
+	chars := make([]char, 0, len(text))
+	prev := ' '
+	for off, r := range text {
+		bonus := 0
+		switch {
+		case off == 0:
+			bonus = bonusFirst + bonusBoundary
+		case !isWord(prev) && isWord(r):
+			bonus = bonusBoundary
+		case unicode.IsLower(prev) && unicode.IsUpper(r):
+			bonus = bonusBoundary // camelCase
+		}
+		chars = append(chars, char{off, r, bonus})
+		prev = r
+	}
+	n, m := len(chars), len(p)
+	if n < m {
+		return 0, nil, false
+	}
+
+	// best[i][j] is the best score of matching p[:i+1] with p[i] at
+	// chars[j]; from records where p[i-1] matched. Texts are labels,
+	// URLs and notes, so the quadratic table stays small.
+	const none = -1 << 30
+	best := make([][]int, m)
+	from := make([][]int, m)
+	for i := range m {
+		best[i] = make([]int, n)
+		from[i] = make([]int, n)
+		for j := range n {
+			best[i][j] = none
+			from[i][j] = -1
+		}
+	}
+	typed := []rune(pattern)
+	gain := func(i, j int) int {
+		g := scoreMatch + chars[j].bonus
+		if i < len(typed) && typed[i] == chars[j].r {
+			g += bonusCase
+		}
+		return g
+	}
+	for j := range n {
+		if unicode.ToLower(chars[j].r) == p[0] {
+			best[0][j] = gain(0, j)
+		}
+	}
+	for i := 1; i < m; i++ {
+		// running is the best over k < j of best[i-1][k] less the gap
+		// penalty from k to j, kept up to date as j moves right.
+		running, runningFrom := none, -1
+		for j := i; j < n; j++ {
+			if running != none {
+				running -= penaltyGapExtend
+			}
+			if k := j - 1; best[i-1][k] != none {
+				// k joins the running best here, paying for opening a
+				// gap; it only counts once j has moved past k+1.
+				if candidate := best[i-1][k] - penaltyGapStart; candidate > running {
+					running, runningFrom = candidate, k
+				}
+			}
+			if unicode.ToLower(chars[j].r) != p[i] {
+				continue
+			}
+			score, src := running, runningFrom
+			if k := j - 1; best[i-1][k] != none {
+				if adjacent := best[i-1][k] + bonusConsecutive; adjacent >= score {
+					score, src = adjacent, k
+				}
+			}
+			if src >= 0 {
+				best[i][j], from[i][j] = score+gain(i, j), src
+			}
+		}
+	}
+
+	end := -1
+	for j := range n {
+		if best[m-1][j] != none && (end < 0 || best[m-1][j] > best[m-1][end]) {
+			end = j
+		}
+	}
+	if end < 0 {
+		return 0, nil, false
+	}
+	positions = make([]int, m)
+	for i, j := m-1, end; i >= 0; i-- {
+		positions[i] = chars[j].off
+		j = from[i][j]
+	}
+	return best[m-1][end], positions, true
+}
+
+func isWord(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// Field is one searchable text of an entry.
+type Field struct {
+	Name   string
+	Text   string
+	weight int // percent applied to match scores
+}
+
+// Fields lists the text of an entry that searches look at. Hidden custom
+// fields and passwords are left out.
+func Fields(label string, data types.Data) []Field {
+	fields := []Field{{"label", label, 150}}
+	add := func(name, text string, weight int) {
+		if strings.TrimSpace(text) != "" && text != "(Not Set)" {
+			fields = append(fields, Field{name, text, weight})
+		}
+	}
+	add("user", data.Lname, 100)
+	for _, u := range data.AllURLs() {
+		add("url", u, 100)
+	}
+	add("folder", data.Folder, 80)
+	for _, tag := range data.Tags {
+		add("tag", tag, 100)
+	}
+	if data.EntryKind() != types.KindLogin {
+		add("kind", data.EntryKind().Title(), 60)
+	}
+	for _, f := range data.Fields {
+		if f.Type != types.FieldHidden {
+			add(strings.ToLower(f.Name), f.Value, 70)
+		}
+	}
+	for _, line := range strings.Split(data.Notes, "\n") {
+		add("notes", line, 60)
+	}
+	return fields
+}
+
+// Hit is where one query term matched.
+type Hit struct {
+	Field     Field
+	Positions []int
+}
+
+// Result is an entry that matched every term of a query.
+type Result struct {
+	Label string
+	Data  types.Data
+	Score int
+	Hits  []Hit // one per term
+}
+
+// Search returns the entries matching query, best first. The query is
+// split on spaces; every term has to match some field, and each term
+// counts its best field.
+func Search(query string, entries map[string]types.Data) []Result {
+	terms := strings.Fields(query)
+	var results []Result
+	for label, data := range entries {
+		fields := Fields(label, data)
+		r := Result{Label: label, Data: data}
+		for _, term := range terms {
+			best, found := 0, false
+			var hit Hit
+			for _, f := range fields {
+				score, pos, ok := Match(term, f.Text)
+				if !ok {
+					continue
+				}
+				// Shorter texts are better matches for the same score,
+				// so a label "gh" beats "github enterprise mirror".
+				score = score*f.weight/100 - len(f.Text)/8
+				if !found || score > best {
+					best, found, hit = score, true, Hit{f, pos}
+				}
+			}
+			if !found {
+				r.Hits = nil
+				break
+			}
+			r.Score += best
+			r.Hits = append(r.Hits, hit)
+		}
+		if len(r.Hits) > 0 {
+			results = append(results, r)
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Label < results[j].Label
+	})
+	return results
+}
+
+// Highlight wraps the characters of text at positions in on and off.
+func Highlight(text string, positions []int, on, off string) string {
+	if len(positions) == 0 {
+		return text
+	}
+	marked := make(map[int]bool, len(positions))
+	for _, p := range positions {
+		marked[p] = true
+	}
+	var b strings.Builder
+	for i, r := range text {
+		if marked[i] {
+			b.WriteString(on)
+			b.WriteRune(r)
+			b.WriteString(off)
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,208 @@
+package securecrypto
+
+import (
+	"encoding/binary"
+	"sync"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// Argon2d (RFC 9106, version 0x13) is what KeePass databases use by
+// default. x/crypto/argon2 only exposes the i and id variants, so the d
+// variant is implemented here; it is only used to open and write KDBX
+// files, never for rook's own keys.
+
+const (
+	argonVersion     = 0x13
+	argonModeD       = 0
+	argonBlockWords  = 128
+	argonSyncPoints  = 4
+	argonBlockLength = argonBlockWords * 8
+)
+
+type argonBlock [argonBlockWords]uint64
+
+// Argon2d derives a keyLen-byte key. memory is in KiB.
+func Argon2d(password, salt []byte, time, memory uint32, threads uint8, keyLen uint32) []byte {
+	return argon2d(password, salt, nil, nil, time, memory, uint32(threads), keyLen)
+}
+
+func argon2d(password, salt, secret, data []byte, time, memory, threads, keyLen uint32) []byte {
+	if time < 1 || threads < 1 {
+		panic("argon2d: invalid parameters")
+	}
+	h0 := argonInitHash(password, salt, secret, data, time, memory, threads, keyLen)
+
+	memory = memory / (argonSyncPoints * threads) * (argonSyncPoints * threads)
+	if memory < 2*argonSyncPoints*threads {
+		memory = 2 * argonSyncPoints * threads
+	}
+	lanes := memory / threads
+	segments := lanes / argonSyncPoints
+
+	B := make([]argonBlock, memory)
+	var buf [argonBlockLength]byte
+	for lane := uint32(0); lane < threads; lane++ {
+		binary.LittleEndian.PutUint32(h0[blake2b.Size+4:], lane)
+		for i := uint32(0); i < 2; i++ {
+			binary.LittleEndian.PutUint32(h0[blake2b.Size:], i)
+			argonHash(buf[:], h0[:])
+			for w := range B[lane*lanes+i] {
+				B[lane*lanes+i][w] = binary.LittleEndian.Uint64(buf[w*8:])
+			}
+		}
+	}
+
+	segment := func(n, slice, lane uint32, wg *sync.WaitGroup) {
+		defer wg.Done()
+		index := uint32(0)
+		if n == 0 && slice == 0 {
+			index = 2
+		}
+		offset := lane*lanes + slice*segments + index
+		for ; index < segments; index, offset = index+1, offset+1 {
+			prev := offset - 1
+			if index == 0 && slice == 0 {
+				prev += lanes
+			}
+			ref := argonIndex(B[prev][0], lanes, segments, threads, n, slice, lane, index)
+			argonCompress(&B[offset], &B[prev], &B[ref])
+		}
+	}
+	for n := uint32(0); n < time; n++ {
+		for slice := uint32(0); slice < argonSyncPoints; slice++ {
+			var wg sync.WaitGroup
+			for lane := uint32(0); lane < threads; lane++ {
+				wg.Add(1)
+				go segment(n, slice, lane, &wg)
+			}
+			wg.Wait()
+		}
+	}
+
+	final := B[memory-1]
+	for lane := uint32(0); lane < threads-1; lane++ {
+		for i, v := range B[lane*lanes+lanes-1] {
+			final[i] ^= v
+		}
+	}
+	for i, v := range final {
+		binary.LittleEndian.PutUint64(buf[i*8:], v)
+	}
+	key := make([]byte, keyLen)
+	argonHash(key, buf[:])
+	return key
+}
+
+func argonInitHash(password, salt, secret, data []byte, time, memory, threads, keyLen uint32) [blake2b.Size + 8]byte {
+	var h0 [blake2b.Size + 8]byte
+	b2, _ := blake2b.New512(nil)
+	for _, v := range []uint32{threads, keyLen, memory, time, argonVersion, argonModeD} {
+		b2.Write(binary.LittleEndian.AppendUint32(nil, v))
+	}
+	for _, field := range [][]byte{password, salt, secret, data} {
+		b2.Write(binary.LittleEndian.AppendUint32(nil, uint32(len(field))))
+		b2.Write(field)
+	}
+	b2.Sum(h0[:0])
+	return h0
+}
+
+// argonHash is the variable-length hash H' of RFC 9106.
+func argonHash(out, in []byte) {
+	prefix := binary.LittleEndian.AppendUint32(nil, uint32(len(out)))
+	if len(out) <= blake2b.Size {
+		b2, _ := blake2b.New(len(out), nil)
+		b2.Write(prefix)
+		b2.Write(in)
+		b2.Sum(out[:0])
+		return
+	}
+
+	b2, _ := blake2b.New512(nil)
+	b2.Write(prefix)
+	b2.Write(in)
+	v := b2.Sum(nil)
+	copy(out, v[:32])
+	out = out[32:]
+	for len(out) > blake2b.Size {
+		sum := blake2b.Sum512(v)
+		v = sum[:]
+		copy(out, v[:32])
+		out = out[32:]
+	}
+	last, _ := blake2b.New(len(out), nil)
+	last.Write(v)
+	last.Sum(out[:0])
+}
+
+// argonIndex maps the pseudo-random value of a block to the index of the
+// block it is mixed with.
+func argonIndex(rand uint64, lanes, segments, threads, n, slice, lane, index uint32) uint32 {
+	refLane := uint32(rand>>32) % threads
+	if n == 0 && slice == 0 {
+		refLane = lane
+	}
+	m, s := 3*segments, ((slice+1)%argonSyncPoints)*segments
+	if lane == refLane {
+		m += index
+	}
+	if n == 0 {
+		m, s = slice*segments, 0
+		if slice == 0 || lane == refLane {
+			m += index
+		}
+	}
+	if index == 0 || lane == refLane {
+		m--
+	}
+	p := rand & 0xFFFFFFFF
+	p = (p * p) >> 32
+	p = (p * uint64(m)) >> 32
+	return refLane*lanes + uint32((uint64(s)+uint64(m)-(p+1))%uint64(lanes))
+}
+
+// argonCompress XORs G(x, y) into out, which is a plain store on the first
+// pass since out starts zeroed.
+func argonCompress(out, x, y *argonBlock) {
+	var r, t argonBlock
+	for i := range r {
+		r[i] = x[i] ^ y[i]
+	}
+	t = r
+	for i := 0; i < argonBlockWords; i += 16 {
+		argonRound(&t, i, i+1, i+2, i+3, i+4, i+5, i+6, i+7, i+8, i+9, i+10, i+11, i+12, i+13, i+14, i+15)
+	}
+	for i := 0; i < 16; i += 2 {
+		argonRound(&t, i, i+1, i+16, i+17, i+32, i+33, i+48, i+49, i+64, i+65, i+80, i+81, i+96, i+97, i+112, i+113)
+	}
+	for i := range out {
+		out[i] ^= r[i] ^ t[i]
+	}
+}
+
+// argonRound applies the BLAKE2b-based permutation P to sixteen words of b.
+func argonRound(b *argonBlock, w ...int) {
+	argonG(b, w[0], w[4], w[8], w[12])
+	argonG(b, w[1], w[5], w[9], w[13])
+	argonG(b, w[2], w[6], w[10], w[14])
+	argonG(b, w[3], w[7], w[11], w[15])
+	argonG(b, w[0], w[5], w[10], w[15])
+	argonG(b, w[1], w[6], w[11], w[12])
+	argonG(b, w[2], w[7], w[8], w[13])
+	argonG(b, w[3], w[4], w[9], w[14])
+}
+
+func argonG(v *argonBlock, a, b, c, d int) {
+	mul := func(x, y uint64) uint64 { return x + y + 2*uint64(uint32(x))*uint64(uint32(y)) }
+	rotr := func(x uint64, n uint) uint64 { return x>>n | x<<(64-n) }
+
+	v[a] = mul(v[a], v[b])
+	v[d] = rotr(v[d]^v[a], 32)
+	v[c] = mul(v[c], v[d])
+	v[b] = rotr(v[b]^v[c], 24)
+	v[a] = mul(v[a], v[b])
+	v[d] = rotr(v[d]^v[a], 16)
+	v[c] = mul(v[c], v[d])
+	v[b] = rotr(v[b]^v[c], 63)
+}
@@ -0,0 +1,44 @@
+package securecrypto
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func TestArgon2dVectors(t *testing.T) {
+	tests := []struct {
+		name                         string
+		password, salt, secret, data []byte
+		time, memory, threads        uint32
+		want                         string
+	}{
+		{
+			// RFC 9106, section 5.1.
+			name:     "RFC 9106",
+			password: bytes.Repeat([]byte{0x01}, 32),
+			salt:     bytes.Repeat([]byte{0x02}, 16),
+			secret:   bytes.Repeat([]byte{0x03}, 8),
+			data:     bytes.Repeat([]byte{0x04}, 12),
+			time:     3, memory: 32, threads: 4,
+			want: "512b391b6f1162975371d30919734294f868e3be3984f3c1a13a4db9fabe4acb",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := argon2d(tt.password, tt.salt, tt.secret, tt.data, tt.time, tt.memory, tt.threads, 32)
+			if hex.EncodeToString(got) != tt.want {
+				t.Fatalf("got %x, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestArgon2dDeterministic(t *testing.T) {
+	a := Argon2d([]byte("pw"), []byte("saltsaltsaltsalt"), 2, 256, 2, 32)
+	b := Argon2d([]byte("pw"), []byte("saltsaltsaltsalt"), 2, 256, 2, 32)
+	c := Argon2d([]byte("pw"), []byte("saltsaltsaltsalT"), 2, 256, 2, 32)
+	if !bytes.Equal(a, b) || bytes.Equal(a, c) {
+		t.Fatal("Argon2d is not a deterministic function of its salt")
+	}
+}
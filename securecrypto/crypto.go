@@ -1,15 +1,15 @@
 package securecrypto
 
 import (
-	"crypto/aes"
-	"crypto/cipher"
 	"crypto/hmac"
+	"crypto/subtle"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
 	"errors"
-	"io"
+	"strings"
 
+	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/scrypt"
 )
 
@@ -21,6 +21,10 @@ const (
 	scryptN  = 1 << 15 // scrypt CPU/memory cost
 	scryptR  = 8
 	scryptP  = 1
+
+	argonTime    = 3
+	argonMemory  = 64 * 1024 // KiB
+	argonThreads = 4
 )
 
 func GenerateSalt() (string, error) {
@@ -31,6 +35,15 @@ func GenerateSalt() (string, error) {
 	return base64.StdEncoding.EncodeToString(salt), nil
 }
 
+// RandomBytes returns n bytes from the system CSPRNG.
+func RandomBytes(n int) ([]byte, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
 func HashWithSalt(password, salt string) (string, error) {
 	saltBytes, err := base64.StdEncoding.DecodeString(salt)
 	if err != nil {
@@ -44,6 +57,15 @@ func HashWithSalt(password, salt string) (string, error) {
 	return base64.StdEncoding.EncodeToString(hash), nil
 }
 
+// HashWithParams hashes password with the KDF described by params and
+// returns it base64 encoded, like HashWithSalt.
+func HashWithParams(password string, params KDFParams) (string, error) {
+	hash, err := DeriveKey([]byte(password), params)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(hash), nil
+}
 
 func VerifyPassword(inputPassword string, hashedPassword string,salt []byte) bool {
 	hashedInput, err := HashWithSalt(inputPassword, string(salt))
@@ -54,44 +76,24 @@ func VerifyPassword(inputPassword string, hashedPassword string,salt []byte) boo
 }
 
 
-func DeriveKey(password []byte, salt []byte) ([]byte, error) {
-	return scrypt.Key(password, salt, scryptN, scryptR, scryptP, keyLen)
-}
-
-
-
-func Encrypt(buffers, key ,salt []byte) ([]byte, error) {
-
-	block, err := aes.NewCipher(key)
-	if err != nil {
-		return nil, err
-	}
-
-	gcm, err := cipher.NewGCM(block)
-	if err != nil {
+// DeriveKey derives an encryption key from password as described by params.
+func DeriveKey(password []byte, params KDFParams) ([]byte, error) {
+	if err := params.validate(); err != nil {
 		return nil, err
 	}
-
-	nonce := make([]byte, nonceLen)
-	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
-		return nil, err
+	switch params.Algorithm {
+	case KDFScrypt:
+		return scrypt.Key(password, params.Salt, params.N, params.R, params.P, keyLen)
+	case KDFArgon2id:
+		return argon2.IDKey(password, params.Salt, params.Time, params.Memory, params.Threads, keyLen), nil
+	default:
+		return nil, ErrUnsupported
 	}
-
-	ciphertext := gcm.Seal(nil, nonce, buffers, nil)
-
-	// Compute HMAC of salt + nonce + ciphertext
-	h := hmac.New(sha256.New, key)
-	h.Write(salt)
-	h.Write(nonce)
-	h.Write(ciphertext)
-	mac := h.Sum(nil)
-
-	full := append(salt, append(nonce, append(ciphertext, mac...)...)...)
-	return full, nil
 }
 
-
-func Decrypt(payload, key []byte) ([]byte, error) {
+// decryptLegacy opens the salt||nonce||ciphertext||hmac blobs written before
+// the versioned envelope.
+func decryptLegacy(payload, key []byte) ([]byte, error) {
 	if len(payload) < saltLen+nonceLen+hmacLen {
 		return nil, errors.New("payload too short")
 	}
@@ -114,12 +116,7 @@ func Decrypt(payload, key []byte) ([]byte, error) {
 		return nil, errors.New("HMAC mismatch: tampered or wrong password")
 	}
 
-	block, err := aes.NewCipher(key)
-	if err != nil {
-		return nil, err
-	}
-
-	gcm, err := cipher.NewGCM(block)
+	gcm, err := newGCM(key)
 	if err != nil {
 		return nil, err
 	}
@@ -131,3 +128,47 @@ func Decrypt(payload, key []byte) ([]byte, error) {
 
 	return buffers, nil
 }
+
+// HashSecret hashes secret with the default KDF and a fresh salt. It returns
+// the stored "hash:salt" form and the KDF costs that produced it.
+func HashSecret(secret string) (string, KDFParams, error) {
+	params, err := DefaultKDF()
+	if err != nil {
+		return "", params, err
+	}
+	hash, err := HashWithParams(secret, params)
+	if err != nil {
+		return "", params, err
+	}
+	salt := base64.StdEncoding.EncodeToString(params.Salt)
+	return hash + ":" + salt, params.Costs(), nil
+}
+
+// VerifySecret verifies secret against a stored "hash:salt" value. Records
+// without KDF costs were hashed with the original scrypt parameters.
+func VerifySecret(secret string, stored []byte, params KDFParams) bool {
+	storedHash, salt, ok := splitHashSalt(stored)
+	if !ok {
+		return false
+	}
+
+	var hash string
+	var err error
+	if params.Algorithm == KDFNone {
+		hash, err = HashWithSalt(secret, salt)
+	} else {
+		params.Salt, err = base64.StdEncoding.DecodeString(salt)
+		if err == nil {
+			hash, err = HashWithParams(secret, params)
+		}
+	}
+	return err == nil && subtle.ConstantTimeCompare([]byte(storedHash), []byte(hash)) == 1
+}
+
+func splitHashSalt(combined []byte) (hash string, salt string, ok bool) {
+	parts := strings.Split(string(combined), ":")
+	if len(parts) != 2 {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
\ No newline at end of file
@@ -0,0 +1,314 @@
+package securecrypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Envelope layout (all integers big-endian):
+//
+//	magic    "ROOK"
+//	version  1 byte
+//	kdf      1 byte, followed by its parameters and a length-prefixed salt
+//	cipher   1 byte
+//	nonce    1 byte length + nonce
+//	sealed   ciphertext and tag
+//
+// Everything before the ciphertext is authenticated as associated data,
+// together with whatever the caller binds to the blob.
+const (
+	envelopeMagic   = "ROOK"
+	EnvelopeVersion = 1
+)
+
+type KDF byte
+
+const (
+	// KDFNone marks blobs sealed with a key that was not derived from a
+	// password, such as a randomly generated data key.
+	KDFNone     KDF = 0
+	KDFScrypt   KDF = 1
+	KDFArgon2id KDF = 2
+)
+
+type Cipher byte
+
+const (
+	CipherAES256GCM Cipher = 1
+)
+
+var (
+	ErrNotEnvelope = errors.New("payload is not a rook envelope")
+	ErrUnsupported = errors.New("unsupported envelope version or algorithm")
+)
+
+// KDFParams records how a key was derived so it can be derived again.
+type KDFParams struct {
+	Algorithm KDF    `json:"algorithm"`
+	Salt      []byte `json:"salt,omitempty"`
+	N         int    `json:"n,omitempty"`
+	R         int    `json:"r,omitempty"`
+	P         int    `json:"p,omitempty"`
+	Time      uint32 `json:"time,omitempty"`
+	Memory    uint32 `json:"memory,omitempty"` // KiB
+	Threads   uint8  `json:"threads,omitempty"`
+}
+
+// Header is the parsed, authenticated prefix of an envelope.
+type Header struct {
+	Version byte
+	KDF     KDFParams
+	Cipher  Cipher
+	Nonce   []byte
+}
+
+// DefaultKDF returns the parameters used for new keys, with a fresh salt.
+func DefaultKDF() (KDFParams, error) {
+	salt, err := RandomBytes(saltLen)
+	if err != nil {
+		return KDFParams{}, err
+	}
+	return KDFParams{
+		Algorithm: KDFArgon2id,
+		Salt:      salt,
+		Time:      argonTime,
+		Memory:    argonMemory,
+		Threads:   argonThreads,
+	}, nil
+}
+
+// LegacyKDF describes keys derived before parameters were recorded.
+func LegacyKDF(salt []byte) KDFParams {
+	return KDFParams{Algorithm: KDFScrypt, Salt: salt, N: scryptN, R: scryptR, P: scryptP}
+}
+
+// Outdated reports whether p is weaker than, or a different algorithm from,
+// what DefaultKDF would pick today.
+func (p KDFParams) Outdated() bool {
+	return p.Algorithm != KDFArgon2id || p.Time < argonTime || p.Memory < argonMemory
+}
+
+// Costs returns a copy of p without its salt, for records that keep the
+// salt elsewhere.
+func (p KDFParams) Costs() KDFParams {
+	p.Salt = nil
+	return p
+}
+
+// KDF parameters come from headers of backups, exports and KDBX files that
+// may be hostile, and are used before anything is authenticated. Costs are
+// capped at a few times the defaults, so a crafted file cannot make rook
+// allocate gigabytes or derive keys for minutes.
+const (
+	MaxArgonMemory = 4 * argonMemory // KiB
+	MaxArgonTime   = 16
+	maxScryptMem   = 4 * 128 * scryptN * scryptR // bytes, 128·N·r
+)
+
+// CheckArgon2 checks Argon2 costs, with memory in KiB, against the caps.
+func CheckArgon2(time, memory uint32, threads uint8) error {
+	if time < 1 || time > MaxArgonTime || threads < 1 || memory < 8*uint32(threads) || memory > MaxArgonMemory {
+		return fmt.Errorf("argon2 parameters t=%d m=%d KiB p=%d are outside the allowed range (at most t=%d, m=%d KiB)",
+			time, memory, threads, MaxArgonTime, MaxArgonMemory)
+	}
+	return nil
+}
+
+func (p KDFParams) validate() error {
+	switch p.Algorithm {
+	case KDFNone:
+		return nil
+	case KDFScrypt:
+		if p.N < 2 || p.N&(p.N-1) != 0 || p.R < 1 || p.P < 1 || p.P > 16 || p.N > maxScryptMem/128/p.R {
+			return fmt.Errorf("invalid scrypt parameters N=%d r=%d p=%d", p.N, p.R, p.P)
+		}
+	case KDFArgon2id:
+		if err := CheckArgon2(p.Time, p.Memory, p.Threads); err != nil {
+			return err
+		}
+	default:
+		return ErrUnsupported
+	}
+	if len(p.Salt) == 0 || len(p.Salt) > 255 {
+		return errors.New("invalid kdf salt")
+	}
+	return nil
+}
+
+// Encrypt seals buffers with key in the latest envelope version. params
+// describes how key was derived and is stored in the clear; aad is bound to
+// the blob but not stored.
+func Encrypt(buffers, key []byte, params KDFParams, aad []byte) ([]byte, error) {
+	if err := params.validate(); err != nil {
+		return nil, err
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, nonceLen)
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	header := marshalHeader(Header{
+		Version: EnvelopeVersion,
+		KDF:     params,
+		Cipher:  CipherAES256GCM,
+		Nonce:   nonce,
+	})
+	return gcm.Seal(header, nonce, buffers, associated(header, aad)), nil
+}
+
+// Decrypt opens an envelope, or a bare blob written before envelopes
+// existed. aad must match what was passed to Encrypt; legacy blobs ignore it.
+func Decrypt(payload, key, aad []byte) ([]byte, error) {
+	header, n, err := parseHeader(payload)
+	if err == ErrNotEnvelope {
+		return decryptLegacy(payload, key)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	buffers, err := gcm.Open(nil, header.Nonce, payload[n:], associated(payload[:n], aad))
+	if err != nil {
+		return nil, errors.New("decryption failed: tampered or wrong key")
+	}
+	return buffers, nil
+}
+
+// Inspect returns the header of an envelope without decrypting it, so the
+// caller can derive the key it was sealed with.
+func Inspect(payload []byte) (Header, error) {
+	header, _, err := parseHeader(payload)
+	return header, err
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func associated(header, aad []byte) []byte {
+	out := make([]byte, 0, len(header)+len(aad))
+	out = append(out, header...)
+	return append(out, aad...)
+}
+
+func marshalHeader(h Header) []byte {
+	out := []byte(envelopeMagic)
+	out = append(out, h.Version, byte(h.KDF.Algorithm))
+	switch h.KDF.Algorithm {
+	case KDFScrypt:
+		out = binary.BigEndian.AppendUint32(out, uint32(h.KDF.N))
+		out = binary.BigEndian.AppendUint32(out, uint32(h.KDF.R))
+		out = binary.BigEndian.AppendUint32(out, uint32(h.KDF.P))
+	case KDFArgon2id:
+		out = binary.BigEndian.AppendUint32(out, h.KDF.Time)
+		out = binary.BigEndian.AppendUint32(out, h.KDF.Memory)
+		out = append(out, h.KDF.Threads)
+	}
+	if h.KDF.Algorithm != KDFNone {
+		out = append(out, byte(len(h.KDF.Salt)))
+		out = append(out, h.KDF.Salt...)
+	}
+	out = append(out, byte(h.Cipher), byte(len(h.Nonce)))
+	return append(out, h.Nonce...)
+}
+
+// parseHeader returns the header and its encoded length.
+func parseHeader(payload []byte) (Header, int, error) {
+	var h Header
+	if len(payload) < len(envelopeMagic)+2 || string(payload[:len(envelopeMagic)]) != envelopeMagic {
+		return h, 0, ErrNotEnvelope
+	}
+	r := reader{buf: payload, off: len(envelopeMagic)}
+
+	h.Version = r.byte()
+	if h.Version != EnvelopeVersion {
+		return h, 0, ErrUnsupported
+	}
+	h.KDF.Algorithm = KDF(r.byte())
+	switch h.KDF.Algorithm {
+	case KDFNone:
+	case KDFScrypt:
+		h.KDF.N = int(r.uint32())
+		h.KDF.R = int(r.uint32())
+		h.KDF.P = int(r.uint32())
+	case KDFArgon2id:
+		h.KDF.Time = r.uint32()
+		h.KDF.Memory = r.uint32()
+		h.KDF.Threads = r.byte()
+	default:
+		return h, 0, ErrUnsupported
+	}
+	if h.KDF.Algorithm != KDFNone {
+		h.KDF.Salt = r.bytes(int(r.byte()))
+	}
+
+	h.Cipher = Cipher(r.byte())
+	if h.Cipher != CipherAES256GCM {
+		return h, 0, ErrUnsupported
+	}
+	h.Nonce = r.bytes(int(r.byte()))
+
+	if r.err != nil {
+		return h, 0, r.err
+	}
+	if len(h.Nonce) != nonceLen {
+		return h, 0, errors.New("invalid envelope nonce")
+	}
+	if err := h.KDF.validate(); err != nil {
+		return h, 0, err
+	}
+	return h, r.off, nil
+}
+
+// reader walks a header and remembers the first short read.
+type reader struct {
+	buf []byte
+	off int
+	err error
+}
+
+func (r *reader) bytes(n int) []byte {
+	if r.err != nil || r.off+n > len(r.buf) {
+		r.err = errors.New("envelope header truncated")
+		return nil
+	}
+	out := r.buf[r.off : r.off+n]
+	r.off += n
+	return out
+}
+
+func (r *reader) byte() byte {
+	b := r.bytes(1)
+	if b == nil {
+		return 0
+	}
+	return b[0]
+}
+
+func (r *reader) uint32() uint32 {
+	b := r.bytes(4)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint32(b)
+}
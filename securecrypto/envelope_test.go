@@ -0,0 +1,207 @@
+package securecrypto
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"testing"
+)
+
+// cheapArgon and cheapScrypt are valid parameters that derive quickly.
+var (
+	cheapArgon  = KDFParams{Algorithm: KDFArgon2id, Salt: bytes.Repeat([]byte{1}, saltLen), Time: 1, Memory: 64, Threads: 1}
+	cheapScrypt = KDFParams{Algorithm: KDFScrypt, Salt: bytes.Repeat([]byte{2}, saltLen), N: 16, R: 1, P: 1}
+)
+
+func testKey(t *testing.T) []byte {
+	t.Helper()
+	key, err := NewDataKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestEnvelopeRoundTrip(t *testing.T) {
+	key := testKey(t)
+	tests := []struct {
+		name   string
+		plain  []byte
+		params KDFParams
+		aad    []byte
+	}{
+		{"empty", nil, KDFParams{}, nil},
+		{"no kdf", []byte("secret"), KDFParams{}, []byte("bound")},
+		{"argon2id", []byte("secret"), cheapArgon, nil},
+		{"scrypt", []byte("secret"), cheapScrypt, []byte("bound")},
+		{"large", bytes.Repeat([]byte("x"), 1<<20), KDFParams{}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sealed, err := Encrypt(tt.plain, key, tt.params, tt.aad)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := Decrypt(sealed, key, tt.aad)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, tt.plain) {
+				t.Fatalf("got %q, want %q", got, tt.plain)
+			}
+			header, err := Inspect(sealed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if header.KDF.Algorithm != tt.params.Algorithm || !bytes.Equal(header.KDF.Salt, tt.params.Salt) {
+				t.Fatalf("header KDF %+v, want %+v", header.KDF, tt.params)
+			}
+		})
+	}
+}
+
+func TestEnvelopeTampering(t *testing.T) {
+	key := testKey(t)
+	aad := []byte("entry key")
+	sealed, err := Encrypt([]byte("secret"), key, cheapArgon, aad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	headerLen := len(sealed) - len("secret") - 16
+
+	flip := func(i int) []byte {
+		out := bytes.Clone(sealed)
+		out[i] ^= 1
+		return out
+	}
+	tests := []struct {
+		name    string
+		payload []byte
+		key     []byte
+		aad     []byte
+	}{
+		{"version", flip(len(envelopeMagic)), key, aad},
+		{"kdf costs", flip(len(envelopeMagic) + 5), key, aad},
+		{"salt", flip(headerLen - nonceLen - 3), key, aad},
+		{"nonce", flip(headerLen - 1), key, aad},
+		{"ciphertext", flip(headerLen), key, aad},
+		{"tag", flip(len(sealed) - 1), key, aad},
+		{"truncated", sealed[:len(sealed)-1], key, aad},
+		{"header only", sealed[:headerLen], key, aad},
+		{"wrong aad", sealed, key, []byte("other key")},
+		{"missing aad", sealed, key, nil},
+		{"wrong key", sealed, testKey(t), aad},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := Decrypt(tt.payload, tt.key, tt.aad); err == nil {
+				t.Fatalf("Decrypt accepted a modified envelope: %q", got)
+			}
+		})
+	}
+}
+
+// sealLegacy builds a salt||nonce||ciphertext||hmac blob as written before
+// the versioned envelope.
+func sealLegacy(t *testing.T, plain, key []byte) []byte {
+	t.Helper()
+	salt := bytes.Repeat([]byte{7}, saltLen)
+	nonce := bytes.Repeat([]byte{9}, nonceLen)
+	gcm, err := newGCM(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ciphertext := gcm.Seal(nil, nonce, plain, nil)
+	h := hmac.New(sha256.New, key)
+	h.Write(salt)
+	h.Write(nonce)
+	h.Write(ciphertext)
+	out := append(append(append(salt, nonce...), ciphertext...), h.Sum(nil)...)
+	return out
+}
+
+func TestDecryptLegacy(t *testing.T) {
+	key := testKey(t)
+	legacy := sealLegacy(t, []byte(`{"lname":"bob"}`), key)
+	mac := bytes.Clone(legacy)
+	mac[len(mac)-1] ^= 1
+	body := bytes.Clone(legacy)
+	body[saltLen+nonceLen] ^= 1
+
+	tests := []struct {
+		name    string
+		payload []byte
+		key     []byte
+		want    string
+		wantErr bool
+	}{
+		{"valid", legacy, key, `{"lname":"bob"}`, false},
+		{"wrong key", legacy, testKey(t), "", true},
+		{"bad mac", mac, key, "", true},
+		{"bad ciphertext", body, key, "", true},
+		{"too short", legacy[:saltLen+nonceLen], key, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Legacy blobs carry no associated data; whatever is passed is
+			// ignored.
+			got, err := Decrypt(tt.payload, tt.key, []byte("ignored"))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Decrypt accepted %q", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKDFCaps(t *testing.T) {
+	key := testKey(t)
+	salt := cheapArgon.Salt
+	tests := []struct {
+		name   string
+		params KDFParams
+	}{
+		{"argon2 memory", KDFParams{Algorithm: KDFArgon2id, Salt: salt, Time: 1, Memory: MaxArgonMemory + 1, Threads: 1}},
+		{"argon2 time", KDFParams{Algorithm: KDFArgon2id, Salt: salt, Time: MaxArgonTime + 1, Memory: 64, Threads: 1}},
+		{"argon2 zero time", KDFParams{Algorithm: KDFArgon2id, Salt: salt, Time: 0, Memory: 64, Threads: 1}},
+		{"argon2 zero threads", KDFParams{Algorithm: KDFArgon2id, Salt: salt, Time: 1, Memory: 64}},
+		{"argon2 memory below lanes", KDFParams{Algorithm: KDFArgon2id, Salt: salt, Time: 1, Memory: 8, Threads: 4}},
+		{"scrypt N", KDFParams{Algorithm: KDFScrypt, Salt: salt, N: 1 << 30, R: 8, P: 1}},
+		{"scrypt N not a power of two", KDFParams{Algorithm: KDFScrypt, Salt: salt, N: 1000, R: 1, P: 1}},
+		{"scrypt r", KDFParams{Algorithm: KDFScrypt, Salt: salt, N: 1 << 15, R: 1 << 20, P: 1}},
+		{"scrypt p", KDFParams{Algorithm: KDFScrypt, Salt: salt, N: 16, R: 1, P: 17}},
+		{"no salt", KDFParams{Algorithm: KDFArgon2id, Time: 1, Memory: 64, Threads: 1}},
+		{"unknown algorithm", KDFParams{Algorithm: 9, Salt: salt}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := DeriveKey([]byte("pw"), tt.params); err == nil {
+				t.Fatal("DeriveKey accepted the parameters")
+			}
+			if _, err := Encrypt([]byte("x"), key, tt.params, nil); err == nil {
+				t.Fatal("Encrypt accepted the parameters")
+			}
+			if tt.params.Algorithm > KDFArgon2id {
+				return
+			}
+			// A header carrying the costs must be refused before any key
+			// is derived from it.
+			crafted := marshalHeader(Header{Version: EnvelopeVersion, KDF: tt.params, Cipher: CipherAES256GCM, Nonce: make([]byte, nonceLen)})
+			crafted = append(crafted, make([]byte, 32)...)
+			if _, err := Inspect(crafted); err == nil {
+				t.Fatal("Inspect accepted the parameters")
+			}
+			if _, _, err := UnwrapKey(crafted, []byte("pw"), nil); err == nil {
+				t.Fatal("UnwrapKey accepted the parameters")
+			}
+		})
+	}
+}
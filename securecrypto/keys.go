@@ -0,0 +1,56 @@
+package securecrypto
+
+import (
+	"crypto/sha256"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// NewDataKey returns a random 256-bit data-encryption key.
+func NewDataKey() ([]byte, error) {
+	return RandomBytes(keyLen)
+}
+
+// WrapKey seals dataKey under a key derived from secret with params. The
+// parameters travel in the envelope header, so UnwrapKey needs only secret.
+func WrapKey(dataKey, secret []byte, params KDFParams, aad []byte) ([]byte, error) {
+	kek, err := DeriveKey(secret, params)
+	if err != nil {
+		return nil, err
+	}
+	defer wipe(kek)
+	return Encrypt(dataKey, kek, params, aad)
+}
+
+// UnwrapKey opens a key sealed by WrapKey and reports the KDF parameters it
+// was wrapped with.
+func UnwrapKey(wrapped, secret, aad []byte) ([]byte, KDFParams, error) {
+	header, err := Inspect(wrapped)
+	if err != nil {
+		return nil, KDFParams{}, err
+	}
+	kek, err := DeriveKey(secret, header.KDF)
+	if err != nil {
+		return nil, header.KDF, err
+	}
+	defer wipe(kek)
+	dataKey, err := Decrypt(wrapped, kek, aad)
+	return dataKey, header.KDF, err
+}
+
+func wipe(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
+// SubKey derives an independent key for purpose from key, so one vault key
+// can serve several roles without reusing it directly.
+func SubKey(key []byte, purpose string) ([]byte, error) {
+	out := make([]byte, keyLen)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, key, nil, []byte(purpose)), out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
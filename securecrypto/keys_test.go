@@ -0,0 +1,65 @@
+package securecrypto
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWrapKey(t *testing.T) {
+	dataKey := testKey(t)
+	secret := []byte("correct horse battery staple")
+	aad := []byte("user bob")
+	tests := []struct {
+		name    string
+		params  KDFParams
+		secret  []byte
+		aad     []byte
+		wantErr bool
+	}{
+		{"argon2id", cheapArgon, secret, aad, false},
+		{"scrypt", cheapScrypt, secret, aad, false},
+		{"wrong aad", cheapArgon, secret, []byte("user eve"), true},
+		{"missing aad", cheapArgon, secret, nil, true},
+		{"wrong secret", cheapArgon, []byte("tr0ub4dor&3"), aad, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wrapped, err := WrapKey(dataKey, secret, tt.params, aad)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, params, err := UnwrapKey(wrapped, tt.secret, tt.aad)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("UnwrapKey succeeded")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, dataKey) {
+				t.Fatal("unwrapped a different key")
+			}
+			if params.Algorithm != tt.params.Algorithm || !bytes.Equal(params.Salt, tt.params.Salt) {
+				t.Fatalf("reported KDF %+v, want %+v", params, tt.params)
+			}
+		})
+	}
+}
+
+func TestSubKey(t *testing.T) {
+	key := testKey(t)
+	a, err := SubKey(key, "index")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := SubKey(key, "manifest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, _ := SubKey(key, "index")
+	if bytes.Equal(a, b) || bytes.Equal(a, key) || !bytes.Equal(a, again) {
+		t.Fatal("sub keys are not independent and deterministic")
+	}
+}
@@ -0,0 +1,114 @@
+package store
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/mbbgs/rook/keyspace"
+	"github.com/mbbgs/rook/securecrypto"
+	"github.com/mbbgs/rook/types"
+)
+
+// entry is what an entry value decrypts to. Badger keys only carry keyed
+// blind indexes of the owner and label, laid out by package keyspace, so
+// the label itself lives here.
+type entry struct {
+	Owner   string      `json:"owner"`
+	Label   types.Label `json:"label"`
+	Data    types.Data  `json:"data"`
+	Version uint64      `json:"version"`          // manifest counter at the last write
+	Deleted time.Time   `json:"deleted,omitzero"` // set while the entry is in the trash
+}
+
+// vaultKeys are the in-memory keys of an unlocked vault.
+type vaultKeys struct {
+	data     []byte // seals entry values
+	index    []byte // blinds owners and labels in badger keys
+	manifest []byte // MACs the manifest and its anchor
+}
+
+func newVaultKeys(dek []byte) (*vaultKeys, error) {
+	index, err := securecrypto.SubKey(dek, "rook:blind-index")
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := securecrypto.SubKey(dek, "rook:manifest")
+	if err != nil {
+		return nil, err
+	}
+	return &vaultKeys{data: append([]byte(nil), dek...), index: index, manifest: manifest}, nil
+}
+
+func (k *vaultKeys) wipe() {
+	for _, b := range [][]byte{k.data, k.index, k.manifest} {
+		for i := range b {
+			b[i] = 0
+		}
+	}
+}
+
+func (k *vaultKeys) blind(parts ...string) string {
+	mac := hmac.New(sha256.New, k.index)
+	for _, p := range parts {
+		mac.Write([]byte(p))
+		mac.Write([]byte{0})
+	}
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// owner is the blinded owner component of entry and trash keys.
+func (k *vaultKeys) owner(owner string) string {
+	return k.blind("owner", owner)[:32]
+}
+
+// ownerPrefix is the key prefix shared by every entry of owner.
+func (k *vaultKeys) ownerPrefix(owner string) []byte {
+	return keyspace.EntryPrefix(k.owner(owner))
+}
+
+func (k *vaultKeys) entryKey(owner string, label types.Label) []byte {
+	return keyspace.EntryKey(k.owner(owner), k.blind("label", owner, string(label)))
+}
+
+// trashPrefix is the key prefix shared by every trashed entry of owner.
+func (k *vaultKeys) trashPrefix(owner string) []byte {
+	return keyspace.TrashPrefix(k.owner(owner))
+}
+
+// trashKey includes the deletion time, so the trash can hold several
+// entries that had the same label.
+func (k *vaultKeys) trashKey(owner string, label types.Label, deleted time.Time) []byte {
+	stamp := deleted.UTC().Format(time.RFC3339Nano)
+	return keyspace.TrashKey(k.owner(owner), k.blind("trashed", owner, string(label), stamp))
+}
+
+// keyFor is where e is stored: in the trash if it was deleted.
+func (k *vaultKeys) keyFor(e entry) []byte {
+	if e.Deleted.IsZero() {
+		return k.entryKey(e.Owner, e.Label)
+	}
+	return k.trashKey(e.Owner, e.Label, e.Deleted)
+}
+
+// seal encrypts e bound to its storage key, so a value copied under a
+// different key fails to open.
+func (k *vaultKeys) seal(key []byte, e entry) ([]byte, error) {
+	plain, err := json.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+	return securecrypto.Encrypt(plain, k.data, securecrypto.KDFParams{}, key)
+}
+
+func (k *vaultKeys) open(key, val []byte) (entry, error) {
+	var e entry
+	plain, err := securecrypto.Decrypt(val, k.data, key)
+	if err != nil {
+		return e, err
+	}
+	err = json.Unmarshal(plain, &e)
+	return e, err
+}
@@ -0,0 +1,184 @@
+package store
+
+import (
+	"strings"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/mbbgs/rook/keyspace"
+	"github.com/mbbgs/rook/types"
+)
+
+// Live entries are indexed by tag and by folder, so a filtered listing only
+// opens the entries it shows. An index key holds blind indexes of the owner,
+// the term and the label, so tags and folder names stay as hidden as labels.
+// A folder is indexed under itself and each folder above it. Index keys are
+// not in the manifest: a tampered index can only make a filter miss
+// entries, as every entry it points to is opened and checked again.
+
+// Filter selects entries by tags and folder; empty fields select all.
+type Filter struct {
+	Tags   []string // entries must have every tag
+	Folder string   // entries must be in this folder or below it
+}
+
+func (f Filter) empty() bool {
+	return len(f.Tags) == 0 && types.CleanFolder(f.Folder) == ""
+}
+
+// Match reports whether data passes the filter.
+func (f Filter) Match(data types.Data) bool {
+	for _, tag := range f.Tags {
+		if !data.HasTag(tag) {
+			return false
+		}
+	}
+	return types.InFolder(data.Folder, f.Folder)
+}
+
+func (k *vaultKeys) tagTerm(owner, tag string) string {
+	return k.blind("tag", owner, strings.ToLower(strings.TrimSpace(tag)))
+}
+
+func (k *vaultKeys) folderTerm(owner, folder string) string {
+	return k.blind("folder", owner, strings.ToLower(types.CleanFolder(folder)))
+}
+
+// indexKeys lists the index keys of e; trashed entries have none.
+func (k *vaultKeys) indexKeys(e entry) [][]byte {
+	if !e.Deleted.IsZero() {
+		return nil
+	}
+	owner, label := k.owner(e.Owner), k.blind("label", e.Owner, string(e.Label))
+	var out [][]byte
+	for _, tag := range e.Data.Tags {
+		out = append(out, keyspace.IndexKey(owner, k.tagTerm(e.Owner, tag), label))
+	}
+	if folder := types.CleanFolder(e.Data.Folder); folder != "" {
+		parts := strings.Split(folder, "/")
+		for i := range parts {
+			path := strings.Join(parts[:i+1], "/")
+			out = append(out, keyspace.IndexKey(owner, k.folderTerm(e.Owner, path), label))
+		}
+	}
+	return out
+}
+
+// reindex replaces the index keys of before, if any, with those of after.
+func (s *Store) reindex(txn *badger.Txn, keys *vaultKeys, before, after *entry) error {
+	if before != nil {
+		for _, key := range keys.indexKeys(*before) {
+			if err := txn.Delete(key); err != nil {
+				return err
+			}
+		}
+	}
+	if after != nil {
+		for _, key := range keys.indexKeys(*after) {
+			if err := txn.Set(key, nil); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// rebuildIndexes drops every index key and indexes entries under keys.
+func (s *Store) rebuildIndexes(txn *badger.Txn, keys *vaultKeys, entries map[string]entry) error {
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = false
+	it := txn.NewIterator(opts)
+	var stale [][]byte
+	prefix := []byte{byte(keyspace.Index)}
+	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+		stale = append(stale, it.Item().KeyCopy(nil))
+	}
+	it.Close()
+	for _, key := range stale {
+		if err := txn.Delete(key); err != nil {
+			return err
+		}
+	}
+	for _, e := range entries {
+		if err := s.reindex(txn, keys, nil, &e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Filtered returns the entries of username that pass f, looking them up
+// through the indexes.
+func (s *Store) Filtered(username string, f Filter) (map[string]types.Data, error) {
+	if f.empty() {
+		return s.GetAllForUser(username)
+	}
+	result := make(map[string]types.Data)
+	if s.keys == nil {
+		return result, ErrLocked
+	}
+	var terms []string
+	for _, tag := range f.Tags {
+		terms = append(terms, s.keys.tagTerm(username, tag))
+	}
+	if folder := types.CleanFolder(f.Folder); folder != "" {
+		terms = append(terms, s.keys.folderTerm(username, folder))
+	}
+	owner := s.keys.owner(username)
+
+	err := s.db.View(func(txn *badger.Txn) error {
+		// Intersect the labels listed under each term.
+		var labels map[string]bool
+		for _, term := range terms {
+			found, err := indexed(txn, owner, term)
+			if err != nil {
+				return err
+			}
+			if labels != nil {
+				for label := range labels {
+					if !found[label] {
+						delete(labels, label)
+					}
+				}
+			} else {
+				labels = found
+			}
+			if len(labels) == 0 {
+				return nil
+			}
+		}
+		for label := range labels {
+			key := keyspace.EntryKey(owner, label)
+			e, err := s.read(txn, key)
+			if err == badger.ErrKeyNotFound {
+				continue // stale index key
+			}
+			if err != nil {
+				return err
+			}
+			if e.Owner == username && f.Match(e.Data) {
+				result[string(e.Label)] = e.Data
+			}
+		}
+		return nil
+	})
+	return result, err
+}
+
+// indexed returns the blinded labels indexed under term.
+func indexed(txn *badger.Txn, owner, term string) (map[string]bool, error) {
+	labels := make(map[string]bool)
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = false
+	it := txn.NewIterator(opts)
+	defer it.Close()
+
+	prefix := keyspace.IndexPrefix(owner, term)
+	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+		k, err := keyspace.Decode(it.Item().Key())
+		if err != nil {
+			return nil, err
+		}
+		labels[k.Parts[2]] = true
+	}
+	return labels, nil
+}
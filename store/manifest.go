@@ -0,0 +1,374 @@
+package store
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/mbbgs/rook/consts"
+	"github.com/mbbgs/rook/keyspace"
+	"github.com/mbbgs/rook/securecrypto"
+	"github.com/mbbgs/rook/utils"
+)
+
+// The manifest lists every entry key with the version it was last written
+// at, under a MAC that also covers a counter bumped on every commit. Entry
+// MACs catch edits to one value; the manifest catches entries that were
+// deleted, added or swapped for older copies. A MACed copy of the counter
+// is kept outside the badger directory so restoring an older directory as
+// a whole shows up as the manifest going backwards.
+var (
+	manifestKey       = keyspace.MetaKey("manifest")
+	legacyManifestKey = []byte("__manifest__")
+)
+
+type manifest struct {
+	VaultID string            `json:"vault_id"`
+	Counter uint64            `json:"counter"`
+	Entries map[string]uint64 `json:"entries"`
+	MAC     []byte            `json:"mac"`
+}
+
+type anchor struct {
+	VaultID string `json:"vault_id"`
+	Counter uint64 `json:"counter"`
+	MAC     []byte `json:"mac"`
+}
+
+// ErrManifestMissing is returned when the manifest is gone although the
+// anchor shows this vault kept one. It is only rebuilt on request, by
+// RebuildManifest, as whatever was deleted with it cannot be told apart.
+var ErrManifestMissing = errors.New("the vault manifest has been deleted; log in to review and rebuild it")
+
+// Report lists what Verify found wrong with the vault.
+type Report struct {
+	Initialized   bool     // no manifest existed; one was built from current entries
+	Deleted       bool     // the manifest is gone but the anchor shows there was one
+	Tampered      bool     // the manifest MAC does not verify
+	RolledBack    bool     // the anchor has seen a later counter than the manifest
+	AnchorMissing bool     // the anchor file is gone although the vault has history
+	AnchorInvalid bool     // the anchor MAC does not verify under this vault key
+	Missing       []string // keys the manifest lists that are gone
+	Extra         []string // entries the manifest does not list
+	Stale         []string // entries whose version differs from the manifest's
+	Unreadable    []string // keys whose value fails to decrypt
+}
+
+// OK reports whether nothing suspicious was found.
+func (r Report) OK() bool {
+	return !r.Deleted && !r.Tampered && !r.RolledBack && !r.AnchorMissing && !r.AnchorInvalid &&
+		len(r.Missing)+len(r.Extra)+len(r.Stale)+len(r.Unreadable) == 0
+}
+
+// isEntryKey reports whether key holds a sealed entry, live or trashed. Keys
+// written before the keyspace are entries unless they are one of the flat
+// "__name__" records.
+func isEntryKey(key []byte) bool {
+	if k, err := keyspace.Decode(key); err == nil {
+		return k.Space == keyspace.Entry || k.Space == keyspace.Trash
+	}
+	return !strings.HasPrefix(string(key), "__")
+}
+
+func (k *vaultKeys) manifestMAC(m *manifest) []byte {
+	keys := make([]string, 0, len(m.Entries))
+	for key := range m.Entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	mac := hmac.New(sha256.New, k.manifest)
+	mac.Write([]byte("rook:manifest\x00" + m.VaultID + "\x00"))
+	mac.Write(binary.BigEndian.AppendUint64(nil, m.Counter))
+	for _, key := range keys {
+		mac.Write([]byte(key))
+		mac.Write([]byte{0})
+		mac.Write(binary.BigEndian.AppendUint64(nil, m.Entries[key]))
+	}
+	return mac.Sum(nil)
+}
+
+func (k *vaultKeys) anchorMAC(a *anchor) []byte {
+	mac := hmac.New(sha256.New, k.manifest)
+	mac.Write([]byte("rook:anchor\x00" + a.VaultID + "\x00"))
+	mac.Write(binary.BigEndian.AppendUint64(nil, a.Counter))
+	return mac.Sum(nil)
+}
+
+// loadManifest reads the manifest, or builds one from the entries on disk
+// when the vault predates it. A manifest that was deleted is not rebuilt.
+func (s *Store) loadManifest(txn *badger.Txn) (*manifest, bool, error) {
+	item, err := getCompat(txn, manifestKey, legacyManifestKey)
+	if err == badger.ErrKeyNotFound {
+		if _, ok := s.anchored(); ok {
+			return nil, false, ErrManifestMissing
+		}
+		m, err := s.buildManifest(txn)
+		return m, false, err
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	var m manifest
+	err = item.Value(func(val []byte) error {
+		return json.Unmarshal(val, &m)
+	})
+	if m.Entries == nil {
+		m.Entries = make(map[string]uint64)
+	}
+	return &m, true, err
+}
+
+// newManifest returns an empty manifest for a vault that has none yet.
+func newManifest() (*manifest, error) {
+	id, err := securecrypto.RandomBytes(16)
+	if err != nil {
+		return nil, err
+	}
+	return &manifest{VaultID: hex.EncodeToString(id), Entries: make(map[string]uint64)}, nil
+}
+
+// buildManifest lists the entries on disk at their sealed versions. Entries
+// still in plain JSON from before values were encrypted have none, and are
+// listed at version zero until UpgradeKeys seals them.
+func (s *Store) buildManifest(txn *badger.Txn) (*manifest, error) {
+	m, err := newManifest()
+	if err != nil {
+		return nil, err
+	}
+	it := txn.NewIterator(badger.DefaultIteratorOptions)
+	defer it.Close()
+
+	for it.Rewind(); it.Valid(); it.Next() {
+		item := it.Item()
+		if !isEntryKey(item.Key()) {
+			continue
+		}
+		val, err := item.ValueCopy(nil)
+		if err != nil {
+			return nil, err
+		}
+		key := item.KeyCopy(nil)
+		if json.Valid(val) {
+			m.Entries[string(key)] = 0
+			continue
+		}
+		e, err := s.keys.open(key, val)
+		if err != nil {
+			return nil, err
+		}
+		m.Entries[string(key)] = e.Version
+	}
+	return m, nil
+}
+
+func saveManifest(txn *badger.Txn, keys *vaultKeys, m *manifest) error {
+	m.MAC = keys.manifestMAC(m)
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return setCompat(txn, manifestKey, legacyManifestKey, data)
+}
+
+// commit runs fn in a read-write transaction with the manifest loaded and
+// its counter already bumped, then saves the manifest MACed under keys in
+// the same transaction. The anchor is left to the caller.
+func (s *Store) commit(keys *vaultKeys, fn func(txn *badger.Txn, m *manifest) error) (*manifest, error) {
+	var m *manifest
+	err := s.db.Update(func(txn *badger.Txn) error {
+		var err error
+		if m, _, err = s.loadManifest(txn); err != nil {
+			return err
+		}
+		m.Counter++
+		if err := fn(txn, m); err != nil {
+			return err
+		}
+		return saveManifest(txn, keys, m)
+	})
+	return m, err
+}
+
+// update is commit under the session keys, followed by the anchor write.
+func (s *Store) update(fn func(txn *badger.Txn, m *manifest) error) error {
+	if s.keys == nil {
+		return ErrLocked
+	}
+	m, err := s.commit(s.keys, fn)
+	if err != nil {
+		return err
+	}
+	s.keys.saveAnchor(m)
+	return nil
+}
+
+func anchorPath() (string, error) {
+	dir, err := utils.GetSessionDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, consts.ANCHOR_PATH), nil
+}
+
+// saveAnchor records the manifest counter outside the store. A failure is
+// only logged: the commit already happened, and the next one retries.
+func (k *vaultKeys) saveAnchor(m *manifest) {
+	a := &anchor{VaultID: m.VaultID, Counter: m.Counter}
+	a.MAC = k.anchorMAC(a)
+	data, err := json.Marshal(a)
+	if err == nil {
+		var path string
+		if path, err = anchorPath(); err == nil {
+			err = os.WriteFile(path, data, 0600)
+		}
+	}
+	if err != nil {
+		utils.Warn("Failed to update vault anchor: " + err.Error())
+	}
+}
+
+func loadAnchor() (*anchor, error) {
+	path, err := anchorPath()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var a anchor
+	if err := json.Unmarshal(data, &a); err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// anchored returns the anchor if it was written under the session keys,
+// which means this vault has had a manifest.
+func (s *Store) anchored() (*anchor, bool) {
+	a, err := loadAnchor()
+	if err != nil || !hmac.Equal(a.MAC, s.keys.anchorMAC(a)) {
+		return nil, false
+	}
+	return a, true
+}
+
+// RebuildManifest replaces a deleted manifest with one listing the entries
+// on disk now. Its counter continues from the anchor's, so later rollback
+// checks still hold.
+func (s *Store) RebuildManifest() error {
+	if s.keys == nil {
+		return ErrLocked
+	}
+	var m *manifest
+	err := s.db.Update(func(txn *badger.Txn) error {
+		var err error
+		if m, err = s.buildManifest(txn); err != nil {
+			return err
+		}
+		if a, ok := s.anchored(); ok {
+			m.VaultID, m.Counter = a.VaultID, a.Counter
+		}
+		m.Counter++
+		return saveManifest(txn, s.keys, m)
+	})
+	if err != nil {
+		return err
+	}
+	s.keys.saveAnchor(m)
+	return nil
+}
+
+// Verify checks every entry against the manifest and the manifest against
+// the anchor. A vault without a manifest gets one built from its current
+// entries, which Report.Initialized flags, unless the anchor shows it had
+// one; that is reported as Deleted and left for RebuildManifest.
+func (s *Store) Verify() (Report, error) {
+	var report Report
+	if s.keys == nil {
+		return report, ErrLocked
+	}
+
+	var m *manifest
+	var found bool
+	err := s.db.View(func(txn *badger.Txn) error {
+		var err error
+		m, found, err = s.loadManifest(txn)
+		if err != nil || !found {
+			return err
+		}
+		if !hmac.Equal(m.MAC, s.keys.manifestMAC(m)) {
+			report.Tampered = true
+		}
+		seen := make(map[string]bool)
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			item := it.Item()
+			if !isEntryKey(item.Key()) {
+				continue
+			}
+			key := string(item.KeyCopy(nil))
+			seen[key] = true
+			val, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			e, err := s.keys.open([]byte(key), val)
+			if err != nil {
+				report.Unreadable = append(report.Unreadable, key)
+				continue
+			}
+			version, listed := m.Entries[key]
+			switch {
+			case !listed:
+				report.Extra = append(report.Extra, string(e.Label))
+			case e.Version != version:
+				report.Stale = append(report.Stale, string(e.Label))
+			}
+		}
+		for key := range m.Entries {
+			if !seen[key] {
+				report.Missing = append(report.Missing, key)
+			}
+		}
+		return nil
+	})
+	if errors.Is(err, ErrManifestMissing) {
+		report.Deleted, report.Tampered = true, true
+		return report, nil
+	}
+	if err != nil {
+		return report, err
+	}
+
+	if !found {
+		report.Initialized = true
+		return report, s.update(func(*badger.Txn, *manifest) error { return nil })
+	}
+
+	a, err := loadAnchor()
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		report.AnchorMissing = m.Counter > 1
+	case err != nil:
+		report.AnchorInvalid = true
+	case a.VaultID != m.VaultID:
+		// Left behind by a wiped vault; the next commit replaces it.
+	case !hmac.Equal(a.MAC, s.keys.anchorMAC(a)):
+		report.AnchorInvalid = true
+	case a.Counter > m.Counter:
+		report.RolledBack = true
+	}
+	return report, nil
+}
@@ -0,0 +1,275 @@
+package store
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/mbbgs/rook/consts"
+	"github.com/mbbgs/rook/keyspace"
+	"github.com/mbbgs/rook/utils"
+)
+
+// The schema version of a vault is kept under versionKey. Vaults written
+// before it existed have none and are at version 0. Opening a vault with a
+// later version than SchemaVersion fails, so an older rook never rewrites
+// data it does not understand. Earlier vaults are brought up to date by
+// Migrate once unlocked, in one transaction, after the whole store has been
+// copied aside.
+var (
+	versionKey       = keyspace.MetaKey("version")
+	legacyVersionKey = []byte("__version__")
+)
+
+// SchemaVersion is the version this build writes. It is the version of the
+// last step in migrations.
+const SchemaVersion = 3
+
+// ErrNewerVault is returned when the vault was written by a newer rook.
+var ErrNewerVault = errors.New("vault was written by a newer version of rook; upgrade rook to open it")
+
+// migration upgrades a vault from version-1 to version. Steps run with the
+// session keys and must leave a vault they already upgraded unchanged, as
+// the version key is not covered by the manifest.
+type migration struct {
+	version int
+	name    string
+	apply   func(s *Store, txn *badger.Txn, m *manifest) error
+}
+
+var migrations = []migration{
+	{1, "date entries saved before creation and modification times were kept", backfillTimestamps},
+	{2, "move the user record, bookkeeping and entries into namespaced keys", moveToKeyspace},
+	{3, "index entries by tag and folder", indexEntries},
+}
+
+// Migration reports what Migrate did.
+type Migration struct {
+	From, To int
+	Applied  []string // names of the steps that ran
+	// Backup is the snapshot of the store taken before they ran. It is
+	// deleted once the upgraded vault verifies, and kept otherwise.
+	Backup string
+}
+
+// Migrate runs the steps between the vault's version and SchemaVersion.
+func (s *Store) Migrate() (Migration, error) {
+	var result Migration
+	if s.keys == nil {
+		return result, ErrLocked
+	}
+	from, err := s.SchemaVersion()
+	if err != nil {
+		return result, err
+	}
+	result.From, result.To = from, from
+	if from > SchemaVersion {
+		return result, ErrNewerVault
+	}
+	if from == SchemaVersion {
+		return result, nil
+	}
+
+	if result.Backup, err = s.snapshot(from); err != nil {
+		return result, fmt.Errorf("backing up the vault before upgrading it: %w", err)
+	}
+	err = s.update(func(txn *badger.Txn, m *manifest) error {
+		for _, step := range migrations {
+			if step.version <= from {
+				continue
+			}
+			if err := step.apply(s, txn, m); err != nil {
+				return fmt.Errorf("schema %d (%s): %w", step.version, step.name, err)
+			}
+			result.Applied = append(result.Applied, step.name)
+		}
+		return setVersion(txn, SchemaVersion)
+	})
+	if err != nil {
+		result.Applied = nil
+		return result, err
+	}
+	result.To = SchemaVersion
+
+	// The snapshot holds the user record and its wrapped keys, so it must
+	// not outlive the need for it: a wipe would leave the vault behind.
+	report, err := s.Verify()
+	if err == nil && report.OK() {
+		if _, err = s.GetUser(); err == nil {
+			err = os.Remove(result.Backup)
+		}
+		if err == nil {
+			result.Backup = ""
+		}
+	}
+	return result, nil
+}
+
+// SchemaVersion reads the version the vault was last written at.
+func (s *Store) SchemaVersion() (int, error) {
+	var version int
+	err := s.db.View(func(txn *badger.Txn) error {
+		var err error
+		version, err = readVersion(txn)
+		return err
+	})
+	return version, err
+}
+
+func readVersion(txn *badger.Txn) (int, error) {
+	item, err := getCompat(txn, versionKey, legacyVersionKey)
+	if err == badger.ErrKeyNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	val, err := item.ValueCopy(nil)
+	if err != nil {
+		return 0, err
+	}
+	if len(val) != 8 {
+		return 0, fmt.Errorf("malformed schema version %x", val)
+	}
+	return int(binary.BigEndian.Uint64(val)), nil
+}
+
+func setVersion(txn *badger.Txn, version int) error {
+	return setCompat(txn, versionKey, legacyVersionKey, binary.BigEndian.AppendUint64(nil, uint64(version)))
+}
+
+// checkVersion refuses vaults written by a newer rook.
+func checkVersion(db *badger.DB) error {
+	return db.View(func(txn *badger.Txn) error {
+		version, err := readVersion(txn)
+		if err == nil && version > SchemaVersion {
+			return fmt.Errorf("%w (schema %d, this build knows %d)", ErrNewerVault, version, SchemaVersion)
+		}
+		return err
+	})
+}
+
+// snapshot writes a badger backup of the whole store next to it, named
+// after the version it holds. The values stay sealed, so the file is as
+// sensitive as the store itself and no more.
+func (s *Store) snapshot(version int) (string, error) {
+	dir, err := utils.GetSessionDir()
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, fmt.Sprintf("%s.v%d", consts.SCHEMA_BACKUP, version))
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return "", err
+	}
+	if _, err = s.db.Backup(f, 0); err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return "", err
+	}
+	return path, nil
+}
+
+// backfillTimestamps gives entries saved before Created and Modified were
+// recorded the time their password was last set, where that is known.
+func backfillTimestamps(s *Store, txn *badger.Txn, m *manifest) error {
+	entries, err := s.loadEntries(txn)
+	if err != nil {
+		return err
+	}
+	for key, e := range entries {
+		if e.Data.Changed.IsZero() || (!e.Data.Created.IsZero() && !e.Data.Modified.IsZero()) {
+			continue
+		}
+		if e.Data.Created.IsZero() {
+			e.Data.Created = e.Data.Changed
+		}
+		if e.Data.Modified.IsZero() {
+			e.Data.Modified = e.Data.Changed
+		}
+		e.Version = m.Counter
+		value, err := s.keys.seal([]byte(key), e)
+		if err != nil {
+			return err
+		}
+		if err := txn.Set([]byte(key), value); err != nil {
+			return err
+		}
+		m.Entries[key] = m.Counter
+	}
+	return nil
+}
+
+// moveToKeyspace reseals entries stored under the flat blind keys used
+// before the keyspace at their namespaced keys, and moves the user record.
+// The manifest and version move when Migrate writes them.
+func moveToKeyspace(s *Store, txn *badger.Txn, m *manifest) error {
+	entries, err := s.loadEntries(txn)
+	if err != nil {
+		return err
+	}
+	for key, e := range entries {
+		if _, err := keyspace.Decode([]byte(key)); err == nil {
+			continue
+		}
+		e.Version = m.Counter
+		if err := s.move(txn, m, []byte(key), e); err != nil {
+			return err
+		}
+	}
+
+	item, err := txn.Get(legacyUserKey)
+	if err == badger.ErrKeyNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	user, err := item.ValueCopy(nil)
+	if err != nil {
+		return err
+	}
+	return setCompat(txn, userKey, legacyUserKey, user)
+}
+
+// indexEntries builds the tag and folder indexes.
+func indexEntries(s *Store, txn *badger.Txn, m *manifest) error {
+	entries, err := s.loadEntries(txn)
+	if err != nil {
+		return err
+	}
+	return s.rebuildIndexes(txn, s.keys, entries)
+}
+
+// Records other than entries lived under flat "__name__" keys before the
+// keyspace. Until a vault is migrated they are read from there, and any
+// write moves them.
+
+// getCompat reads key, or legacy if key has not been written yet.
+func getCompat(txn *badger.Txn, key, legacy []byte) (*badger.Item, error) {
+	item, err := txn.Get(key)
+	if err == badger.ErrKeyNotFound {
+		item, err = txn.Get(legacy)
+	}
+	return item, err
+}
+
+// setCompat writes key and drops legacy.
+func setCompat(txn *badger.Txn, key, legacy, val []byte) error {
+	if err := txn.Delete(legacy); err != nil {
+		return err
+	}
+	return txn.Set(key, val)
+}
@@ -0,0 +1,167 @@
+package store
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/mbbgs/rook/consts"
+	"github.com/mbbgs/rook/keyspace"
+	"github.com/mbbgs/rook/types"
+)
+
+// openTestStore opens a store in a fresh session directory.
+func openTestStore(t *testing.T) *Store {
+	t.Helper()
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	s, err := NewStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// writeBaseline lays out a vault as the first releases of rook wrote it:
+// the user record under "__user__" and entries as plain JSON under
+// "username:label", with no schema version, manifest or anchor.
+func writeBaseline(t *testing.T, s *Store, raw map[string]string) {
+	t.Helper()
+	err := s.db.Update(func(txn *badger.Txn) error {
+		for k, v := range raw {
+			if err := txn.Set([]byte(k), []byte(v)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMigrateBaseline(t *testing.T) {
+	s := openTestStore(t)
+	writeBaseline(t, s, map[string]string{
+		"__user__":     `{"username":"bob","Password":"aGFzaA==","created_at":"2024-01-02T03:04:05Z","updated_at":"2024-01-02T03:04:05Z"}`,
+		"bob:github":   `{"lname":"bob@example.com","lpassword":"aHVudGVyMg==","lurl":"https://github.com","last_access":"0001-01-01T00:00:00Z","owner":"bob"}`,
+		"bob:My Bank":  `{"lname":"bob","lpassword":"c2VjcmV0","lurl":"(Not Set)","last_access":"0001-01-01T00:00:00Z","owner":"bob"}`,
+		"bob:a:b\x00c": `{"lname":"odd","lpassword":"eA==","lurl":"(Not Set)","last_access":"0001-01-01T00:00:00Z","owner":"bob"}`,
+	})
+	want := map[string]string{"github": "hunter2", "My Bank": "secret", "a:b\x00c": "x"}
+
+	if v, err := s.SchemaVersion(); err != nil || v != 0 {
+		t.Fatalf("baseline schema version = %d, %v; want 0", v, err)
+	}
+	if err := s.Unlock(bytes.Repeat([]byte{7}, 32)); err != nil {
+		t.Fatal(err)
+	}
+	moved, err := s.UpgradeKeys("bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moved != len(want) {
+		t.Fatalf("UpgradeKeys moved %d entries, want %d", moved, len(want))
+	}
+
+	result, err := s.Migrate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.From != 0 || result.To != SchemaVersion || len(result.Applied) != len(migrations) {
+		t.Fatalf("Migrate = %+v, want 0 -> %d with every step", result, SchemaVersion)
+	}
+	if result.Backup != "" {
+		t.Fatalf("snapshot %s kept after a clean upgrade", result.Backup)
+	}
+	dir := filepath.Join(os.Getenv("XDG_CACHE_HOME"), ".rook")
+	if left, _ := filepath.Glob(filepath.Join(dir, consts.SCHEMA_BACKUP+".v*")); len(left) > 0 {
+		t.Fatalf("snapshots left behind: %v", left)
+	}
+	if v, _ := s.SchemaVersion(); v != SchemaVersion {
+		t.Fatalf("schema version = %d, want %d", v, SchemaVersion)
+	}
+
+	user, err := s.GetUser()
+	if err != nil || user.Username != "bob" {
+		t.Fatalf("GetUser = %+v, %v", user, err)
+	}
+	entries, err := s.GetAllForUser("bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("got %d entries, want %d", len(entries), len(want))
+	}
+	for label, password := range want {
+		data, err := s.GetByLabel("bob", types.Label(label))
+		if err != nil {
+			t.Fatalf("GetByLabel(%q): %v", label, err)
+		}
+		if string(data.Lpassword) != password {
+			t.Fatalf("%q has password %q, want %q", label, data.Lpassword, password)
+		}
+	}
+
+	// Nothing may be left under the flat keys, and every key left must be
+	// a well-formed namespaced one.
+	err = s.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			if _, err := keyspace.Decode(it.Item().Key()); err != nil {
+				t.Errorf("key %q: %v", it.Item().Key(), err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := s.Verify()
+	if err != nil || !report.OK() {
+		t.Fatalf("Verify = %+v, %v", report, err)
+	}
+
+	// The indexes built by the last step must serve later writes.
+	data := entries["github"]
+	data.Tags = []string{"work"}
+	if err := s.AddToStore("bob", "github", data); err != nil {
+		t.Fatal(err)
+	}
+	tagged, err := s.Filtered("bob", Filter{Tags: []string{"work"}})
+	if err != nil || len(tagged) != 1 {
+		t.Fatalf("Filtered = %v, %v; want github", tagged, err)
+	}
+
+	again, err := s.Migrate()
+	if err != nil || len(again.Applied) != 0 || again.Backup != "" {
+		t.Fatalf("second Migrate = %+v, %v; want nothing to do", again, err)
+	}
+}
+
+func TestMigrateLocked(t *testing.T) {
+	s := openTestStore(t)
+	if _, err := s.Migrate(); !errors.Is(err, ErrLocked) {
+		t.Fatalf("Migrate on a locked store = %v, want ErrLocked", err)
+	}
+}
+
+func TestNewerVaultRefused(t *testing.T) {
+	s := openTestStore(t)
+	err := s.db.Update(func(txn *badger.Txn) error {
+		return txn.Set(versionKey, binary.BigEndian.AppendUint64(nil, SchemaVersion+1))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+	if _, err := NewStore(); !errors.Is(err, ErrNewerVault) {
+		t.Fatalf("NewStore on a newer vault = %v, want ErrNewerVault", err)
+	}
+}
@@ -1,23 +1,34 @@
 package store
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"path/filepath"
-  "github.com/dgraph-io/badger/v4/options"
+	"time"
 	"github.com/dgraph-io/badger/v4"
 	"github.com/mbbgs/rook/consts"
+	"github.com/mbbgs/rook/keyspace"
 	"github.com/mbbgs/rook/models"
+	"github.com/mbbgs/rook/securecrypto"
 	"github.com/mbbgs/rook/types"
 	"github.com/mbbgs/rook/utils"
 )
 
 type Store struct {
-	db *badger.DB
+	db   *badger.DB
+	keys *vaultKeys
+
+	historyLimit int
 }
 
-const userKey = "__user__"
+var (
+	userKey       = keyspace.UserKey()
+	legacyUserKey = []byte("__user__")
+)
+
+var ErrLocked = errors.New("vault is locked")
 
 /**
 func NewStore() (*Store, error) {
@@ -46,33 +57,61 @@ func NewStore() (*Store, error) {
 
     opts := badger.DefaultOptions(path).
         WithValueLogFileSize(8 << 20).                      // 8MB log chunks
-        WithTableLoadingMode(options.FileIO).               // avoid mmap
-        WithValueLogLoadingMode(options.FileIO).            // avoid mmap
         WithNumMemtables(1).
         WithNumLevelZeroTables(1).
-        WithMaxTableSize(1 << 20).                          // 1MB SST tables
-        WithTruncate(true).
+        WithBaseTableSize(1 << 20).                         // 1MB SST tables
         WithLogger(nil)
 
     db, err := badger.Open(opts)
     if err != nil {
         return nil, err
     }
+    if err := checkVersion(db); err != nil {
+        db.Close()
+        return nil, err
+    }
 
     return &Store{db: db}, nil
 }
 
 func (s *Store) Close() error {
 	if s.db != nil {
-		return s.db.Close()
+		err := s.db.Close()
+		s.db = nil
+		return err
 	}
 	return nil
 }
 
+// Unlock keeps the vault data key, and the index key derived from it, in
+// memory so entries can be sealed and opened for the rest of the session.
+func (s *Store) Unlock(key []byte) error {
+	keys, err := newVaultKeys(key)
+	if err != nil {
+		return err
+	}
+	s.Lock()
+	s.keys = keys
+	return nil
+}
+
+// Unlocked reports whether the store holds session keys.
+func (s *Store) Unlocked() bool {
+	return s.keys != nil
+}
+
+// Lock wipes the session keys; every entry operation fails until Unlock.
+func (s *Store) Lock() {
+	if s.keys != nil {
+		s.keys.wipe()
+		s.keys = nil
+	}
+}
+
 // One-device-one-user logic
 func (s *Store) IsUser() (bool, error) {
 	err := s.db.View(func(txn *badger.Txn) error {
-		_, err := txn.Get([]byte(userKey))
+		_, err := getCompat(txn, userKey, legacyUserKey)
 		return err
 	})
 
@@ -100,15 +139,19 @@ func (s *Store) CreateUser(user *models.User) error {
 	if err != nil {
 		return err
 	}
+	// A new vault starts at the current schema; nothing needs migrating.
 	return s.db.Update(func(txn *badger.Txn) error {
-		return txn.Set([]byte(userKey), data)
+		if err := setVersion(txn, SchemaVersion); err != nil {
+			return err
+		}
+		return setCompat(txn, userKey, legacyUserKey, data)
 	})
 }
 
 func (s *Store) GetUser() (*models.User, error) {
 	var user models.User
 	err := s.db.View(func(txn *badger.Txn) error {
-		item, err := txn.Get([]byte(userKey))
+		item, err := getCompat(txn, userKey, legacyUserKey)
 		if err != nil {
 			return err
 		}
@@ -132,53 +175,189 @@ func (s *Store) UpdateUser(user *models.User) error {
 		return err
 	}
 	return s.db.Update(func(txn *badger.Txn) error {
-		return txn.Set([]byte(userKey), data)
+		return setCompat(txn, userKey, legacyUserKey, data)
 	})
 }
 
 func (s *Store) DeleteUser() error {
 	return s.db.Update(func(txn *badger.Txn) error {
-		return txn.Delete([]byte(userKey))
+		if err := txn.Delete(legacyUserKey); err != nil {
+			return err
+		}
+		return txn.Delete(userKey)
 	})
 }
 
-// Entry store logic (still uses username as part of key)
+// Entry keys are blind indexes of the owner and label; see blind.go.
 func (s *Store) AddToStore(username string, label types.Label, data types.Data) error {
-	key := s.makeKey(username, label)
-	value, err := json.Marshal(data)
+	if s.keys == nil {
+		return ErrLocked
+	}
+	key := s.keys.entryKey(username, label)
+	return s.update(func(txn *badger.Txn, m *manifest) error {
+		var before *entry
+		if old, err := s.read(txn, key); err == nil {
+			before = &old
+		} else if err != badger.ErrKeyNotFound {
+			return err
+		}
+		if err := s.carryOver(txn, key, &data); err != nil {
+			return err
+		}
+		e := entry{Owner: username, Label: label, Data: data, Version: m.Counter}
+		value, err := s.keys.seal(key, e)
+		if err != nil {
+			return err
+		}
+		if err := s.reindex(txn, s.keys, before, &e); err != nil {
+			return err
+		}
+		m.Entries[string(key)] = m.Counter
+		return txn.Set(key, value)
+	})
+}
+
+// carryOver fills in what a write keeps from the entry it replaces. If the
+// password differs, Changed becomes now and the old password goes to the
+// front of the history; otherwise the stored Changed is kept. A Changed,
+// Created or History the caller set is kept as given. Modified is always
+// now.
+func (s *Store) carryOver(txn *badger.Txn, key []byte, data *types.Data) error {
+	data.Modified = time.Now()
+	item, err := txn.Get(key)
+	if err == badger.ErrKeyNotFound {
+		if data.Changed.IsZero() {
+			data.Changed = data.Modified
+		}
+		if data.Created.IsZero() {
+			data.Created = data.Modified
+		}
+		return nil
+	}
 	if err != nil {
 		return err
 	}
-	return s.db.Update(func(txn *badger.Txn) error {
-		return txn.Set([]byte(key), value)
+	return item.Value(func(val []byte) error {
+		old, err := s.keys.open(key, val)
+		if err != nil {
+			return err
+		}
+		if data.Created.IsZero() {
+			data.Created = old.Data.Created
+		}
+		if data.LastAccess.IsZero() {
+			data.LastAccess = old.Data.LastAccess
+		}
+		if data.History == nil {
+			data.History = old.Data.History
+		}
+		if bytes.Equal(old.Data.Lpassword, data.Lpassword) {
+			if data.Changed.IsZero() {
+				data.Changed = old.Data.Changed
+			}
+			return nil
+		}
+		now := time.Now()
+		if data.Changed.IsZero() {
+			data.Changed = now
+		}
+		rev := types.Revision{Password: old.Data.Lpassword, Set: old.Data.Changed, Replaced: now}
+		data.History = append([]types.Revision{rev}, data.History...)
+		if limit := s.HistoryLimit(); len(data.History) > limit {
+			data.History = data.History[:limit]
+		}
+		return nil
+	})
+}
+
+// DefaultHistoryLimit is how many earlier passwords an entry keeps unless
+// the user chose otherwise.
+const DefaultHistoryLimit = 10
+
+// SetHistoryLimit sets how many earlier passwords writes keep, using the
+// convention of models.User.HistoryLimit.
+func (s *Store) SetHistoryLimit(n int) {
+	s.historyLimit = n
+}
+
+// HistoryLimit is the number of earlier passwords writes keep.
+func (s *Store) HistoryLimit() int {
+	switch {
+	case s.historyLimit < 0:
+		return 0
+	case s.historyLimit == 0:
+		return DefaultHistoryLimit
+	}
+	return s.historyLimit
+}
+
+// Touch records that the entry for label was just read, without counting
+// it as a modification.
+func (s *Store) Touch(username string, label types.Label) error {
+	if s.keys == nil {
+		return ErrLocked
+	}
+	key := s.keys.entryKey(username, label)
+	return s.update(func(txn *badger.Txn, m *manifest) error {
+		e, err := s.read(txn, key)
+		if err != nil {
+			return err
+		}
+		e.Data.LastAccess = time.Now()
+		e.Version = m.Counter
+		value, err := s.keys.seal(key, e)
+		if err != nil {
+			return err
+		}
+		m.Entries[string(key)] = m.Counter
+		return txn.Set(key, value)
 	})
 }
 
 func (s *Store) GetByLabel(username string, label types.Label) (types.Data, error) {
 	var data types.Data
-	key := s.makeKey(username, label)
+	if s.keys == nil {
+		return data, ErrLocked
+	}
+	key := s.keys.entryKey(username, label)
 	err := s.db.View(func(txn *badger.Txn) error {
-		item, err := txn.Get([]byte(key))
+		item, err := txn.Get(key)
 		if err != nil {
 			return err
 		}
 		return item.Value(func(val []byte) error {
-			return json.Unmarshal(val, &data)
+			e, err := s.keys.open(key, val)
+			data = e.Data
+			return err
 		})
 	})
 	return data, err
 }
 
 func (s *Store) RemoveFromStore(username string, label types.Label) error {
-	key := s.makeKey(username, label)
-	return s.db.Update(func(txn *badger.Txn) error {
-		return txn.Delete([]byte(key))
+	if s.keys == nil {
+		return ErrLocked
+	}
+	key := s.keys.entryKey(username, label)
+	return s.update(func(txn *badger.Txn, m *manifest) error {
+		e, err := s.read(txn, key)
+		if err != nil {
+			return err
+		}
+		if err := s.reindex(txn, s.keys, &e, nil); err != nil {
+			return err
+		}
+		delete(m.Entries, string(key))
+		return txn.Delete(key)
 	})
 }
 
 func (s *Store) GetAllForUser(username string) (map[string]types.Data, error) {
 	result := make(map[string]types.Data)
-	prefix := []byte(username + ":")
+	if s.keys == nil {
+		return result, ErrLocked
+	}
+	prefix := s.keys.ownerPrefix(username)
 	err := s.db.View(func(txn *badger.Txn) error {
 		it := txn.NewIterator(badger.DefaultIteratorOptions)
 		defer it.Close()
@@ -187,12 +366,11 @@ func (s *Store) GetAllForUser(username string) (map[string]types.Data, error) {
 			item := it.Item()
 			k := item.Key()
 			err := item.Value(func(val []byte) error {
-				var d types.Data
-				if err := json.Unmarshal(val, &d); err != nil {
+				e, err := s.keys.open(k, val)
+				if err != nil {
 					return err
 				}
-				label := string(k[len(prefix):])
-				result[label] = d
+				result[string(e.Label)] = e.Data
 				return nil
 			})
 			if err != nil {
@@ -205,7 +383,10 @@ func (s *Store) GetAllForUser(username string) (map[string]types.Data, error) {
 }
 
 func (s *Store) CountForUser(username string) (int, error) {
-	prefix := []byte(username + "::")
+	if s.keys == nil {
+		return 0, ErrLocked
+	}
+	prefix := s.keys.ownerPrefix(username)
 	count := 0
 
 	err := s.db.View(func(txn *badger.Txn) error {
@@ -223,11 +404,15 @@ func (s *Store) CountForUser(username string) (int, error) {
 	return count, err
 }
 
+// Get returns the sealed value stored for label.
 func (s *Store) Get(username, label string) ([]byte, error) {
 	var val []byte
-	key := fmt.Sprintf("%s::%s", username, label)
+	if s.keys == nil {
+		return nil, ErrLocked
+	}
+	key := s.keys.entryKey(username, types.Label(label))
 	err := s.db.View(func(txn *badger.Txn) error {
-		item, err := txn.Get([]byte(key))
+		item, err := txn.Get(key)
 		if err != nil {
 			return err
 		}
@@ -239,6 +424,304 @@ func (s *Store) Get(username, label string) ([]byte, error) {
 	return val, err
 }
 
-func (s *Store) makeKey(username string, label types.Label) string {
-	return username + ":" + string(label)
-}
\ No newline at end of file
+// Rekey re-encrypts every entry under a new data key, moving it to the key
+// blinded by the new index key, and writes the updated user record in the
+// same transaction, so a crash leaves the old vault intact. The result is
+// read back before the old key is dropped; if it does not match, the old
+// values are written back.
+func (s *Store) Rekey(user *models.User, key []byte) error {
+	if s.keys == nil {
+		return ErrLocked
+	}
+	next, err := newVaultKeys(key)
+	if err != nil {
+		return err
+	}
+	userData, err := json.Marshal(user)
+	if err != nil {
+		return err
+	}
+
+	var snapshot map[string][]byte
+	var entries map[string]entry
+	m, err := s.commit(next, func(txn *badger.Txn, m *manifest) error {
+		var err error
+		if snapshot, err = dump(txn); err != nil {
+			return err
+		}
+		if entries, err = s.loadEntries(txn); err != nil {
+			return err
+		}
+		m.Entries = make(map[string]uint64)
+		for oldKey := range entries {
+			if err := txn.Delete([]byte(oldKey)); err != nil {
+				return err
+			}
+		}
+		for oldKey, e := range entries {
+			e.Version = m.Counter
+			entries[oldKey] = e
+			k := next.keyFor(e)
+			v, err := next.seal(k, e)
+			if err != nil {
+				return err
+			}
+			if err := txn.Set(k, v); err != nil {
+				return err
+			}
+			m.Entries[string(k)] = m.Counter
+		}
+		if err := s.rebuildIndexes(txn, next, entries); err != nil {
+			return err
+		}
+		return setCompat(txn, userKey, legacyUserKey, userData)
+	})
+	if err != nil {
+		next.wipe()
+		return err
+	}
+
+	if err := s.verifyRekey(next, entries); err != nil {
+		next.wipe()
+		if rerr := s.restore(snapshot); rerr != nil {
+			return fmt.Errorf("rekey verification failed (%v) and restoring the old vault failed: %w", err, rerr)
+		}
+		return fmt.Errorf("rekey verification failed, old key kept: %w", err)
+	}
+
+	next.saveAnchor(m)
+	s.Lock()
+	s.keys = next
+	return nil
+}
+
+// verifyRekey checks that every entry opens under keys and matches what
+// was there before.
+func (s *Store) verifyRekey(keys *vaultKeys, want map[string]entry) error {
+	return s.db.View(func(txn *badger.Txn) error {
+		for _, e := range want {
+			k := keys.keyFor(e)
+			item, err := txn.Get(k)
+			if err != nil {
+				return fmt.Errorf("entry %q: %w", e.Label, err)
+			}
+			val, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			got, err := keys.open(k, val)
+			if err != nil {
+				return fmt.Errorf("entry %q: %w", e.Label, err)
+			}
+			a, _ := json.Marshal(got)
+			b, _ := json.Marshal(e)
+			if !bytes.Equal(a, b) {
+				return fmt.Errorf("entry %q changed during rekey", e.Label)
+			}
+		}
+		return nil
+	})
+}
+
+// dump copies every key and value in the vault.
+func dump(txn *badger.Txn) (map[string][]byte, error) {
+	out := make(map[string][]byte)
+	it := txn.NewIterator(badger.DefaultIteratorOptions)
+	defer it.Close()
+
+	for it.Rewind(); it.Valid(); it.Next() {
+		val, err := it.Item().ValueCopy(nil)
+		if err != nil {
+			return nil, err
+		}
+		out[string(it.Item().KeyCopy(nil))] = val
+	}
+	return out, nil
+}
+
+// restore replaces the whole vault with snapshot in one transaction.
+func (s *Store) restore(snapshot map[string][]byte) error {
+	return s.db.Update(func(txn *badger.Txn) error {
+		current, err := dump(txn)
+		if err != nil {
+			return err
+		}
+		for k := range current {
+			if err := txn.Delete([]byte(k)); err != nil {
+				return err
+			}
+		}
+		for k, v := range snapshot {
+			if err := txn.Set([]byte(k), v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Replace discards the whole vault and writes user, entries and trash in
+// its place, sealed under key, in one transaction with a fresh manifest.
+// The store is left unlocked with key.
+func (s *Store) Replace(user *models.User, key []byte, entries map[string]types.Data, trash []Trashed) error {
+	next, err := newVaultKeys(key)
+	if err != nil {
+		return err
+	}
+	userData, err := json.Marshal(user)
+	if err != nil {
+		next.wipe()
+		return err
+	}
+
+	var m *manifest
+	err = s.db.Update(func(txn *badger.Txn) error {
+		current, err := dump(txn)
+		if err != nil {
+			return err
+		}
+		for k := range current {
+			if err := txn.Delete([]byte(k)); err != nil {
+				return err
+			}
+		}
+		if m, err = newManifest(); err != nil {
+			return err
+		}
+		m.Counter = 1
+		for label, data := range entries {
+			e := entry{Owner: user.Username, Label: types.Label(label), Data: data, Version: m.Counter}
+			k := next.entryKey(e.Owner, e.Label)
+			v, err := next.seal(k, e)
+			if err != nil {
+				return err
+			}
+			if err := txn.Set(k, v); err != nil {
+				return err
+			}
+			if err := s.reindex(txn, next, nil, &e); err != nil {
+				return err
+			}
+			m.Entries[string(k)] = m.Counter
+		}
+		for _, t := range trash {
+			e := entry{Owner: user.Username, Label: t.Label, Data: t.Data, Deleted: t.Deleted, Version: m.Counter}
+			k := next.keyFor(e)
+			v, err := next.seal(k, e)
+			if err != nil {
+				return err
+			}
+			if err := txn.Set(k, v); err != nil {
+				return err
+			}
+			m.Entries[string(k)] = m.Counter
+		}
+		if err := txn.Set(userKey, userData); err != nil {
+			return err
+		}
+		if err := setVersion(txn, SchemaVersion); err != nil {
+			return err
+		}
+		return saveManifest(txn, next, m)
+	})
+	if err != nil {
+		next.wipe()
+		return err
+	}
+
+	next.saveAnchor(m)
+	s.Lock()
+	s.keys = next
+	return nil
+}
+
+// UpgradeKeys moves entries still stored under "username:label" keys to
+// blind-indexed keys, in one transaction, sealing any still in plain JSON.
+// It reports how many moved.
+func (s *Store) UpgradeKeys(username string) (int, error) {
+	if s.keys == nil {
+		return 0, ErrLocked
+	}
+	prefix := []byte(username + ":")
+	legacy := make(map[string][]byte)
+	err := s.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			val, err := it.Item().ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			legacy[string(it.Item().Key())] = val
+		}
+		return nil
+	})
+	if err != nil || len(legacy) == 0 {
+		return 0, err
+	}
+
+	err = s.update(func(txn *badger.Txn, m *manifest) error {
+		for oldKey, val := range legacy {
+			// Entries saved before values were encrypted are plain JSON;
+			// they are sealed as they move.
+			plain := val
+			if !json.Valid(val) {
+				if plain, err = securecrypto.Decrypt(val, s.keys.data, []byte(oldKey)); err != nil {
+					return err
+				}
+			}
+			var data types.Data
+			if err := json.Unmarshal(plain, &data); err != nil {
+				return err
+			}
+			e := entry{Owner: username, Label: types.Label(oldKey[len(prefix):]), Data: data, Version: m.Counter}
+			k := s.keys.entryKey(e.Owner, e.Label)
+			v, err := s.keys.seal(k, e)
+			if err != nil {
+				return err
+			}
+			if err := txn.Set(k, v); err != nil {
+				return err
+			}
+			if err := txn.Delete([]byte(oldKey)); err != nil {
+				return err
+			}
+			if err := s.reindex(txn, s.keys, nil, &e); err != nil {
+				return err
+			}
+			delete(m.Entries, oldKey)
+			m.Entries[string(k)] = m.Counter
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(legacy), nil
+}
+
+// loadEntries decrypts every entry in the vault, keyed by storage key.
+func (s *Store) loadEntries(txn *badger.Txn) (map[string]entry, error) {
+	entries := make(map[string]entry)
+	it := txn.NewIterator(badger.DefaultIteratorOptions)
+	defer it.Close()
+
+	for it.Rewind(); it.Valid(); it.Next() {
+		item := it.Item()
+		if !isEntryKey(item.Key()) {
+			continue
+		}
+		val, err := item.ValueCopy(nil)
+		if err != nil {
+			return nil, err
+		}
+		k := item.KeyCopy(nil)
+		e, err := s.keys.open(k, val)
+		if err != nil {
+			return nil, err
+		}
+		entries[string(k)] = e
+	}
+	return entries, nil
+}
@@ -0,0 +1,193 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/mbbgs/rook/types"
+)
+
+// Removed entries are moved to the trash, sealed like any other entry and
+// listed in the manifest, until they are restored or purged.
+
+// DefaultTrashRetention is how long trashed entries are kept unless the
+// user chose otherwise.
+const DefaultTrashRetention = 30 * 24 * time.Hour
+
+// ErrLabelTaken is returned when restoring over a live entry.
+var ErrLabelTaken = errors.New("an entry with that label already exists")
+
+// Trashed is an entry in the trash.
+type Trashed struct {
+	Label   types.Label
+	Data    types.Data
+	Deleted time.Time
+}
+
+// Trash moves the entry for label to the trash.
+func (s *Store) Trash(username string, label types.Label) error {
+	if s.keys == nil {
+		return ErrLocked
+	}
+	key := s.keys.entryKey(username, label)
+	return s.update(func(txn *badger.Txn, m *manifest) error {
+		e, err := s.read(txn, key)
+		if err != nil {
+			return err
+		}
+		if err := s.reindex(txn, s.keys, &e, nil); err != nil {
+			return err
+		}
+		e.Deleted = time.Now()
+		e.Version = m.Counter
+		return s.move(txn, m, key, e)
+	})
+}
+
+// GetTrash lists the trashed entries of username, newest first.
+func (s *Store) GetTrash(username string) ([]Trashed, error) {
+	if s.keys == nil {
+		return nil, ErrLocked
+	}
+	var out []Trashed
+	err := s.db.View(func(txn *badger.Txn) error {
+		entries, err := s.trashed(txn, username)
+		for _, e := range entries {
+			out = append(out, Trashed{Label: e.Label, Data: e.Data, Deleted: e.Deleted})
+		}
+		return err
+	})
+	return out, err
+}
+
+// RestoreFromTrash moves the most recently trashed entry for label back.
+// It fails with ErrLabelTaken if a live entry already uses the label.
+func (s *Store) RestoreFromTrash(username string, label types.Label) error {
+	if s.keys == nil {
+		return ErrLocked
+	}
+	return s.update(func(txn *badger.Txn, m *manifest) error {
+		if _, err := txn.Get(s.keys.entryKey(username, label)); err == nil {
+			return ErrLabelTaken
+		} else if err != badger.ErrKeyNotFound {
+			return err
+		}
+		entries, err := s.trashed(txn, username)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if e.Label != label {
+				continue
+			}
+			key := s.keys.keyFor(e)
+			e.Deleted = time.Time{}
+			e.Version = m.Counter
+			if err := s.reindex(txn, s.keys, nil, &e); err != nil {
+				return err
+			}
+			return s.move(txn, m, key, e)
+		}
+		return fmt.Errorf("%q is not in the trash: %w", label, badger.ErrKeyNotFound)
+	})
+}
+
+// PurgeTrash permanently deletes entries trashed before cutoff and reports
+// how many went. A zero cutoff empties the trash. It runs at every login,
+// so when nothing is due it returns without writing, leaving the manifest
+// counter and anchor as they were.
+func (s *Store) PurgeTrash(username string, cutoff time.Time) (int, error) {
+	if s.keys == nil {
+		return 0, ErrLocked
+	}
+	due := func(e entry) bool { return cutoff.IsZero() || e.Deleted.Before(cutoff) }
+	var pending bool
+	err := s.db.View(func(txn *badger.Txn) error {
+		entries, err := s.trashed(txn, username)
+		pending = slices.ContainsFunc(entries, due)
+		return err
+	})
+	if err != nil || !pending {
+		return 0, err
+	}
+
+	purged := 0
+	err = s.update(func(txn *badger.Txn, m *manifest) error {
+		purged = 0
+		entries, err := s.trashed(txn, username)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if !due(e) {
+				continue
+			}
+			key := s.keys.keyFor(e)
+			if err := txn.Delete(key); err != nil {
+				return err
+			}
+			delete(m.Entries, string(key))
+			purged++
+		}
+		return nil
+	})
+	return purged, err
+}
+
+// read opens the entry stored under key.
+func (s *Store) read(txn *badger.Txn, key []byte) (entry, error) {
+	var e entry
+	item, err := txn.Get(key)
+	if err != nil {
+		return e, err
+	}
+	err = item.Value(func(val []byte) error {
+		e, err = s.keys.open(key, val)
+		return err
+	})
+	return e, err
+}
+
+// move reseals e under the key it now belongs at and drops it from from.
+func (s *Store) move(txn *badger.Txn, m *manifest, from []byte, e entry) error {
+	to := s.keys.keyFor(e)
+	value, err := s.keys.seal(to, e)
+	if err != nil {
+		return err
+	}
+	if err := txn.Delete(from); err != nil {
+		return err
+	}
+	if err := txn.Set(to, value); err != nil {
+		return err
+	}
+	delete(m.Entries, string(from))
+	m.Entries[string(to)] = m.Counter
+	return nil
+}
+
+// trashed reads the trashed entries of username, newest first.
+func (s *Store) trashed(txn *badger.Txn, username string) ([]entry, error) {
+	var out []entry
+	prefix := s.keys.trashPrefix(username)
+	it := txn.NewIterator(badger.DefaultIteratorOptions)
+	defer it.Close()
+
+	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+		val, err := it.Item().ValueCopy(nil)
+		if err != nil {
+			return nil, err
+		}
+		e, err := s.keys.open(it.Item().KeyCopy(nil), val)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, e)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Deleted.After(out[j].Deleted) })
+	return out, nil
+}
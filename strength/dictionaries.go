@@ -0,0 +1,76 @@
+package strength
+
+import (
+	_ "embed"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/mbbgs/rook/generator"
+)
+
+// The embedded lists are ordered from most to least common; a word's rank is
+// its line number.
+var (
+	//go:embed passwords.txt
+	passwordList string
+	//go:embed english.txt
+	englishList string
+	//go:embed names.txt
+	nameList string
+)
+
+type dictionary struct {
+	name  string
+	ranks map[string]int
+}
+
+func ranked(name string, words []string) dictionary {
+	d := dictionary{name: name, ranks: make(map[string]int, len(words))}
+	for i, w := range words {
+		w = strings.ToLower(strings.TrimSpace(w))
+		if _, ok := d.ranks[w]; w != "" && !ok {
+			d.ranks[w] = i + 1
+		}
+	}
+	return d
+}
+
+var dictionaries = sync.OnceValue(func() []dictionary {
+	// Diceware words are unranked: each costs an attacker the whole list.
+	words := generator.Words()
+	diceware := dictionary{name: "diceware", ranks: make(map[string]int, len(words))}
+	for _, w := range words {
+		diceware.ranks[w] = len(words)
+	}
+	return []dictionary{
+		ranked("passwords", strings.Split(passwordList, "\n")),
+		ranked("english", strings.Split(englishList, "\n")),
+		ranked("names", strings.Split(nameList, "\n")),
+		diceware,
+	}
+})
+
+// userDictionary ranks the caller's inputs, and the words and host names
+// inside them, ahead of everything else.
+func userDictionary(inputs []string) *dictionary {
+	var words []string
+	for _, in := range inputs {
+		in = strings.TrimSpace(in)
+		if in == "" || in == "(Not Set)" {
+			continue
+		}
+		words = append(words, in)
+		if u, err := url.Parse(in); err == nil && u.Hostname() != "" {
+			words = append(words, strings.TrimPrefix(u.Hostname(), "www."))
+		}
+		words = append(words, strings.FieldsFunc(in, func(r rune) bool {
+			return !isLower(r) && !isUpper(r) && !isDigit(r)
+		})...)
+	}
+	if len(words) == 0 {
+		return nil
+	}
+	d := ranked("user_inputs", words)
+	return &d
+}
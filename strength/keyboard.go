@@ -0,0 +1,175 @@
+package strength
+
+import (
+	"math"
+	"strings"
+	"sync"
+)
+
+// graph is a keyboard layout: which keys neighbour which, and in what
+// direction.
+type graph struct {
+	name    string
+	keys    map[rune]int  // character to key
+	shifted map[rune]bool // characters typed with shift
+	adj     []map[int]int // key to neighbour key to direction
+	starts  float64       // number of keys
+	degree  float64       // average number of neighbours
+}
+
+type key struct {
+	chars string // unshifted, then shifted if there is one
+	row   int
+	x     float64
+}
+
+// qwertyRows are the rows of a US keyboard, each with the horizontal offset
+// of its first key in key widths.
+var qwertyRows = []struct {
+	keys   string
+	offset float64
+}{
+	{"`~ 1! 2@ 3# 4$ 5% 6^ 7& 8* 9( 0) -_ =+", 0},
+	{"qQ wW eE rR tT yY uU iI oO pP [{ ]} \\|", 1.5},
+	{"aA sS dD fF gG hH jJ kK lL ;: '\"", 1.75},
+	{"zZ xX cC vV bB nN mM ,< .> /?", 2.25},
+}
+
+// keypadKeys lay out a numeric keypad on a grid.
+var keypadKeys = []key{
+	{"/", 0, 1}, {"*", 0, 2}, {"-", 0, 3},
+	{"7", 1, 0}, {"8", 1, 1}, {"9", 1, 2}, {"+", 1, 3},
+	{"4", 2, 0}, {"5", 2, 1}, {"6", 2, 2},
+	{"1", 3, 0}, {"2", 3, 1}, {"3", 3, 2},
+	{"0", 4, 0}, {".", 4, 2},
+}
+
+var graphs = sync.OnceValue(func() []*graph {
+	var qwerty []key
+	for row, r := range qwertyRows {
+		for i, chars := range strings.Fields(r.keys) {
+			qwerty = append(qwerty, key{chars, row, r.offset + float64(i)})
+		}
+	}
+	// On a staggered keyboard a key touches two keys in each neighbouring
+	// row; on the keypad grid it touches up to eight keys around it.
+	staggered := func(a, b key) (int, bool) {
+		dr, dx := b.row-a.row, b.x-a.x
+		switch {
+		case dr == 0 && math.Abs(dx) == 1:
+			return int(math.Copysign(1, dx)), true
+		case (dr == 1 || dr == -1) && math.Abs(dx) <= 0.75:
+			return 10*dr + int(math.Copysign(1, dx)), true
+		}
+		return 0, false
+	}
+	grid := func(a, b key) (int, bool) {
+		dr, dc := b.row-a.row, int(b.x-a.x)
+		if dr == 0 && dc == 0 || abs(dr) > 1 || abs(dc) > 1 {
+			return 0, false
+		}
+		return 10*dr + dc, true
+	}
+	return []*graph{
+		newGraph("qwerty", qwerty, staggered),
+		newGraph("keypad", keypadKeys, grid),
+	}
+})
+
+func newGraph(name string, keys []key, near func(a, b key) (int, bool)) *graph {
+	g := &graph{
+		name:    name,
+		keys:    make(map[rune]int),
+		shifted: make(map[rune]bool),
+		adj:     make([]map[int]int, len(keys)),
+	}
+	edges := 0
+	for i, k := range keys {
+		for n, r := range []rune(k.chars) {
+			g.keys[r] = i
+			g.shifted[r] = n == 1
+		}
+		g.adj[i] = make(map[int]int)
+		for j, other := range keys {
+			if dir, ok := near(k, other); ok && i != j {
+				g.adj[i][j] = dir
+				edges++
+			}
+		}
+	}
+	g.starts = float64(len(keys))
+	g.degree = float64(edges) / g.starts
+	return g
+}
+
+func spatialMatches(runes []rune) []*Match {
+	var out []*Match
+	for _, g := range graphs() {
+		for i := 0; i+2 < len(runes); {
+			cur, ok := g.keys[runes[i]]
+			if !ok {
+				i++
+				continue
+			}
+			j, turns, lastDir, shifted := i, 0, 0, 0
+			if g.shifted[runes[i]] || isUpper(runes[i]) {
+				shifted++
+			}
+			for j+1 < len(runes) {
+				next, ok := g.keys[runes[j+1]]
+				if !ok {
+					break
+				}
+				dir, ok := g.adj[cur][next]
+				if !ok {
+					break
+				}
+				if dir != lastDir {
+					turns++
+					lastDir = dir
+				}
+				if g.shifted[runes[j+1]] || isUpper(runes[j+1]) {
+					shifted++
+				}
+				cur = next
+				j++
+			}
+			if j-i >= 2 {
+				out = append(out, &Match{
+					Pattern: Spatial, I: i, J: j,
+					Token: string(runes[i : j+1]),
+					Graph: g.name, Turns: turns, Shifted: shifted,
+				})
+				i = j
+				continue
+			}
+			i++
+		}
+	}
+	return out
+}
+
+func spatialGuesses(m *Match) float64 {
+	var g *graph
+	for _, candidate := range graphs() {
+		if candidate.name == m.Graph {
+			g = candidate
+		}
+	}
+	length := len([]rune(m.Token))
+	total := 0.0
+	for i := 2; i <= length; i++ {
+		for j := 1; j <= m.Turns && j <= i-1; j++ {
+			total += binomial(i-1, j-1) * g.starts * math.Pow(g.degree, float64(j))
+		}
+	}
+	if m.Shifted > 0 {
+		unshifted := length - m.Shifted
+		if unshifted == 0 {
+			total *= 2
+		} else {
+			total *= variations(m.Shifted, unshifted)
+		}
+	}
+	return total
+}
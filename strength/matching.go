@@ -0,0 +1,579 @@
+package strength
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Pattern names the kind of a match.
+type Pattern string
+
+const (
+	Dictionary Pattern = "dictionary"
+	Spatial    Pattern = "spatial"
+	Repeat     Pattern = "repeat"
+	Sequence   Pattern = "sequence"
+	Year       Pattern = "year"
+	Date       Pattern = "date"
+	Bruteforce Pattern = "bruteforce"
+)
+
+// Match is a run of the password explained by one pattern. I and J are
+// inclusive rune offsets.
+type Match struct {
+	Pattern Pattern
+	I, J    int
+	Token   string
+	Guesses float64
+
+	// Dictionary
+	Dict     string
+	Rank     int
+	Reversed bool
+	L33t     bool
+	Sub      map[rune]rune // substituted character to the letter it stands for
+
+	// Spatial
+	Graph   string
+	Turns   int
+	Shifted int
+
+	// Repeat
+	Base        string
+	Repeats     int
+	baseGuesses float64
+
+	// Sequence
+	Ascending bool
+
+	// Year and Date
+	Year      int
+	Separator string
+}
+
+func allMatches(runes []rune, dicts []dictionary) []*Match {
+	var out []*Match
+	out = append(out, dictionaryMatches(runes, dicts)...)
+	out = append(out, reverseDictionaryMatches(runes, dicts)...)
+	out = append(out, l33tMatches(runes, dicts)...)
+	out = append(out, spatialMatches(runes)...)
+	out = append(out, repeatMatches(runes, dicts)...)
+	out = append(out, sequenceMatches(runes)...)
+	out = append(out, yearMatches(runes)...)
+	out = append(out, dateMatches(runes)...)
+	return out
+}
+
+// maxWordLength bounds dictionary lookups; no list holds longer words.
+const maxWordLength = 32
+
+func dictionaryMatches(runes []rune, dicts []dictionary) []*Match {
+	lower := []rune(strings.ToLower(string(runes)))
+	if len(lower) != len(runes) {
+		lower = runes // lowercasing changed the length; match as typed
+	}
+	var out []*Match
+	for i := range lower {
+		for j := i; j < len(lower) && j-i < maxWordLength; j++ {
+			word := string(lower[i : j+1])
+			for _, d := range dicts {
+				if rank, ok := d.ranks[word]; ok {
+					out = append(out, &Match{
+						Pattern: Dictionary, I: i, J: j,
+						Token: string(runes[i : j+1]),
+						Dict:  d.name, Rank: rank,
+					})
+				}
+			}
+		}
+	}
+	return out
+}
+
+func reverseDictionaryMatches(runes []rune, dicts []dictionary) []*Match {
+	n := len(runes)
+	reversed := make([]rune, n)
+	for i, r := range runes {
+		reversed[n-1-i] = r
+	}
+	var out []*Match
+	for _, m := range dictionaryMatches(reversed, dicts) {
+		if m.J == m.I || m.Token == reverse(m.Token) {
+			continue // palindromes are already matched forwards
+		}
+		m.I, m.J = n-1-m.J, n-1-m.I
+		m.Token = string(runes[m.I : m.J+1])
+		m.Reversed = true
+		out = append(out, m)
+	}
+	return out
+}
+
+func reverse(s string) string {
+	r := []rune(s)
+	for i, j := 0, len(r)-1; i < j; i, j = i+1, j-1 {
+		r[i], r[j] = r[j], r[i]
+	}
+	return string(r)
+}
+
+// l33tTable lists the letters each substitution commonly stands for.
+var l33tTable = map[rune][]rune{
+	'4': {'a'}, '@': {'a'},
+	'8': {'b'},
+	'(': {'c'}, '{': {'c'}, '[': {'c'}, '<': {'c'},
+	'3': {'e'},
+	'6': {'g'}, '9': {'g'},
+	'1': {'i', 'l'}, '!': {'i'}, '|': {'i', 'l'},
+	'7': {'l', 't'},
+	'0': {'o'},
+	'$': {'s'}, '5': {'s'},
+	'+': {'t'},
+	'%': {'x'},
+	'2': {'z'},
+}
+
+// maxL33tSubs bounds how many ways of reading ambiguous substitutions are
+// tried.
+const maxL33tSubs = 64
+
+func l33tMatches(runes []rune, dicts []dictionary) []*Match {
+	var present []rune
+	seen := map[rune]bool{}
+	for _, r := range runes {
+		if _, ok := l33tTable[r]; ok && !seen[r] {
+			seen[r] = true
+			present = append(present, r)
+		}
+	}
+	if len(present) == 0 {
+		return nil
+	}
+
+	subs := []map[rune]rune{{}}
+	for _, c := range present {
+		var next []map[rune]rune
+		for _, s := range subs {
+			for _, letter := range l33tTable[c] {
+				if len(next) == maxL33tSubs {
+					break
+				}
+				m := make(map[rune]rune, len(s)+1)
+				for k, v := range s {
+					m[k] = v
+				}
+				m[c] = letter
+				next = append(next, m)
+			}
+		}
+		subs = next
+	}
+
+	var out []*Match
+	found := map[[3]int]bool{} // i, j, rank per dictionary is enough to dedupe
+	for _, sub := range subs {
+		translated := make([]rune, len(runes))
+		for i, r := range runes {
+			if letter, ok := sub[r]; ok {
+				translated[i] = letter
+			} else {
+				translated[i] = r
+			}
+		}
+		for _, m := range dictionaryMatches(translated, dicts) {
+			token := runes[m.I : m.J+1]
+			used := map[rune]rune{}
+			for _, r := range token {
+				if letter, ok := sub[r]; ok {
+					used[r] = letter
+				}
+			}
+			if len(used) == 0 || len(token) == 1 {
+				continue
+			}
+			key := [3]int{m.I, m.J, m.Rank}
+			if found[key] {
+				continue
+			}
+			found[key] = true
+			m.Token, m.L33t, m.Sub = string(token), true, used
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+func repeatMatches(runes []rune, dicts []dictionary) []*Match {
+	var out []*Match
+	n := len(runes)
+	for i := 0; i < n; {
+		bestBase, bestCount := 0, 0
+		for b := 1; b <= (n-i)/2; b++ {
+			count := 1
+			for i+(count+1)*b <= n && string(runes[i+count*b:i+(count+1)*b]) == string(runes[i:i+b]) {
+				count++
+			}
+			if count >= 2 && count*b > bestBase*bestCount {
+				bestBase, bestCount = b, count
+			}
+		}
+		if bestCount == 0 {
+			i++
+			continue
+		}
+		base := runes[i : i+bestBase]
+		logG, _ := search(base, allMatches(base, dicts))
+		j := i + bestBase*bestCount - 1
+		out = append(out, &Match{
+			Pattern: Repeat, I: i, J: j,
+			Token:   string(runes[i : j+1]),
+			Base:    string(base),
+			Repeats: bestCount, baseGuesses: math.Pow(10, logG),
+		})
+		i = j + 1
+	}
+	return out
+}
+
+// maxSequenceDelta is the largest step between characters still read as a
+// sequence, as in "aceg" or "1357".
+const maxSequenceDelta = 5
+
+func sequenceMatches(runes []rune) []*Match {
+	var out []*Match
+	n := len(runes)
+	for i := 0; i+2 < n; {
+		delta := runes[i+1] - runes[i]
+		j := i + 1
+		for j+1 < n && runes[j+1]-runes[j] == delta {
+			j++
+		}
+		token := runes[i : j+1]
+		if j-i >= 2 && delta != 0 && abs(int(delta)) <= maxSequenceDelta && sameClass(token) {
+			out = append(out, &Match{
+				Pattern: Sequence, I: i, J: j,
+				Token: string(token), Ascending: delta > 0,
+			})
+			i = j
+			continue
+		}
+		i++
+	}
+	return out
+}
+
+func sameClass(runes []rune) bool {
+	for _, is := range []func(rune) bool{isLower, isUpper, isDigit} {
+		all := true
+		for _, r := range runes {
+			if !is(r) {
+				all = false
+				break
+			}
+		}
+		if all {
+			return true
+		}
+	}
+	return false
+}
+
+func isLower(r rune) bool { return r >= 'a' && r <= 'z' }
+func isUpper(r rune) bool { return r >= 'A' && r <= 'Z' }
+func isDigit(r rune) bool { return r >= '0' && r <= '9' }
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+func digitsOnly(runes []rune) bool {
+	for _, r := range runes {
+		if !isDigit(r) {
+			return false
+		}
+	}
+	return true
+}
+
+func yearMatches(runes []rune) []*Match {
+	var out []*Match
+	for i := 0; i+4 <= len(runes); i++ {
+		token := runes[i : i+4]
+		if !digitsOnly(token) {
+			continue
+		}
+		if y, _ := strconv.Atoi(string(token)); y >= 1900 && y <= 2099 {
+			out = append(out, &Match{Pattern: Year, I: i, J: i + 3, Token: string(token), Year: y})
+		}
+	}
+	return out
+}
+
+// dateSplits are the ways to cut a run of 4 to 8 digits into three parts.
+var dateSplits = map[int][][2]int{
+	4: {{1, 2}, {2, 3}},
+	5: {{1, 3}, {2, 3}},
+	6: {{1, 2}, {2, 4}, {4, 5}},
+	7: {{1, 3}, {2, 3}, {4, 5}, {4, 6}},
+	8: {{2, 4}, {4, 6}},
+}
+
+func dateMatches(runes []rune) []*Match {
+	var out []*Match
+	ref := time.Now().Year()
+	n := len(runes)
+
+	// Without separators: 1991, 13091991, 911309 and so on.
+	for i := 0; i+4 <= n; i++ {
+		for j := i + 3; j < i+8 && j < n; j++ {
+			token := runes[i : j+1]
+			if !digitsOnly(token) {
+				continue
+			}
+			best, found := 0, false
+			for _, s := range dateSplits[len(token)] {
+				a, _ := strconv.Atoi(string(token[:s[0]]))
+				b, _ := strconv.Atoi(string(token[s[0]:s[1]]))
+				c, _ := strconv.Atoi(string(token[s[1]:]))
+				if y, ok := dmy([3]int{a, b, c}); ok && (!found || abs(y-ref) < abs(best-ref)) {
+					best, found = y, true
+				}
+			}
+			if found {
+				out = append(out, &Match{Pattern: Date, I: i, J: j, Token: string(token), Year: best})
+			}
+		}
+	}
+
+	// With separators: 13/9/1991, 1991-09-13, 9.13.91.
+	for i := 0; i+6 <= n; i++ {
+		for j := i + 5; j < i+10 && j < n; j++ {
+			token := string(runes[i : j+1])
+			if y, sep, ok := separatedDate(token); ok {
+				out = append(out, &Match{Pattern: Date, I: i, J: j, Token: token, Year: y, Separator: sep})
+			}
+		}
+	}
+	return out
+}
+
+func separatedDate(token string) (int, string, bool) {
+	sepAt := strings.IndexFunc(token, func(r rune) bool { return !isDigit(r) })
+	if sepAt < 1 || sepAt > 4 {
+		return 0, "", false
+	}
+	sep := token[sepAt : sepAt+1]
+	if !strings.Contains(" /\\_.-", sep) {
+		return 0, "", false
+	}
+	parts := strings.Split(token, sep)
+	if len(parts) != 3 || len(parts[1]) < 1 || len(parts[1]) > 2 || len(parts[2]) < 1 || len(parts[2]) > 4 {
+		return 0, "", false
+	}
+	var ints [3]int
+	for k, p := range parts {
+		if !digitsOnly([]rune(p)) {
+			return 0, "", false
+		}
+		ints[k], _ = strconv.Atoi(p)
+	}
+	y, ok := dmy(ints)
+	return y, sep, ok
+}
+
+// dmy reads three integers as a day, month and year in some order and
+// returns the year.
+func dmy(ints [3]int) (int, bool) {
+	if ints[1] > 31 || ints[1] <= 0 {
+		return 0, false
+	}
+	over12, over31, under1 := 0, 0, 0
+	for _, v := range ints {
+		if v > 99 && v < 1000 || v > 2050 {
+			return 0, false
+		}
+		if v > 31 {
+			over31++
+		}
+		if v > 12 {
+			over12++
+		}
+		if v <= 0 {
+			under1++
+		}
+	}
+	if over31 >= 2 || over12 == 3 || under1 >= 2 {
+		return 0, false
+	}
+	splits := [][3]int{{ints[2], ints[0], ints[1]}, {ints[0], ints[1], ints[2]}}
+	for _, s := range splits {
+		if s[0] >= 1000 && s[0] <= 2050 {
+			if dm(s[1], s[2]) {
+				return s[0], true
+			}
+			return 0, false
+		}
+	}
+	for _, s := range splits {
+		if dm(s[1], s[2]) {
+			return twoDigitYear(s[0]), true
+		}
+	}
+	return 0, false
+}
+
+func dm(a, b int) bool {
+	return a >= 1 && a <= 31 && b >= 1 && b <= 12 || b >= 1 && b <= 31 && a >= 1 && a <= 12
+}
+
+func twoDigitYear(y int) int {
+	switch {
+	case y > 99:
+		return y
+	case y > 50:
+		return 1900 + y
+	}
+	return 2000 + y
+}
+
+// Guess estimation.
+
+const (
+	minSubmatchGuessesSingle = 10
+	minSubmatchGuessesMulti  = 50
+	minYearSpace             = 20
+)
+
+// guesses estimates how many guesses m costs an attacker on its own. n is
+// the length of the whole password; parts of it have a floor so that no
+// decomposition gets cheaper by splitting hairs.
+func guesses(m *Match, n int) float64 {
+	length := m.J - m.I + 1
+	min := 1.0
+	if length < n {
+		min = minSubmatchGuessesMulti
+		if length == 1 {
+			min = minSubmatchGuessesSingle
+		}
+	}
+	var g float64
+	switch m.Pattern {
+	case Bruteforce:
+		g = math.Pow(10, float64(length))
+		floor := float64(minSubmatchGuessesMulti + 1)
+		if length == 1 {
+			floor = minSubmatchGuessesSingle + 1
+		}
+		g = math.Max(g, floor)
+	case Dictionary:
+		g = float64(m.Rank) * uppercaseVariations(m.Token) * l33tVariations(m)
+		if m.Reversed {
+			g *= 2
+		}
+	case Spatial:
+		g = spatialGuesses(m)
+	case Repeat:
+		g = m.baseGuesses * float64(m.Repeats)
+	case Sequence:
+		first := []rune(m.Token)[0]
+		base := 26.0
+		switch {
+		case strings.ContainsRune("aAzZ019", first):
+			base = 4
+		case isDigit(first):
+			base = 10
+		}
+		if !m.Ascending {
+			base *= 2
+		}
+		g = base * float64(length)
+	case Year:
+		g = yearSpace(m.Year)
+	case Date:
+		g = yearSpace(m.Year) * 365
+		if m.Separator != "" {
+			g *= 4
+		}
+	}
+	return math.Max(g, min)
+}
+
+func yearSpace(year int) float64 {
+	return math.Max(float64(abs(year-time.Now().Year())), minYearSpace)
+}
+
+func uppercaseVariations(token string) float64 {
+	lower := strings.ToLower(token)
+	if token == lower {
+		return 1
+	}
+	runes := []rune(token)
+	rest := string(runes[1:])
+	upper := strings.ToUpper(token)
+	switch {
+	case token == upper,
+		unicode.IsUpper(runes[0]) && rest == strings.ToLower(rest),
+		unicode.IsUpper(runes[len(runes)-1]) && string(runes[:len(runes)-1]) == strings.ToLower(string(runes[:len(runes)-1])):
+		return 2
+	}
+	u, l := 0, 0
+	for _, r := range runes {
+		switch {
+		case unicode.IsUpper(r):
+			u++
+		case unicode.IsLower(r):
+			l++
+		}
+	}
+	return variations(u, l)
+}
+
+func l33tVariations(m *Match) float64 {
+	if !m.L33t {
+		return 1
+	}
+	v := 1.0
+	for sub, letter := range m.Sub {
+		s, u := 0, 0
+		for _, r := range strings.ToLower(m.Token) {
+			switch r {
+			case sub:
+				s++
+			case letter:
+				u++
+			}
+		}
+		if s == 0 || u == 0 {
+			v *= 2
+		} else {
+			v *= variations(s, u)
+		}
+	}
+	return v
+}
+
+// variations counts the ways to pick up to min(a, b) of a+b positions.
+func variations(a, b int) float64 {
+	total := 0.0
+	for i := 1; i <= a && i <= b; i++ {
+		total += binomial(a+b, i)
+	}
+	return math.Max(total, 1)
+}
+
+func binomial(n, k int) float64 {
+	if k > n {
+		return 0
+	}
+	r := 1.0
+	for d := 1; d <= k; d++ {
+		r = r * float64(n-k+d) / float64(d)
+	}
+	return r
+}
@@ -0,0 +1,327 @@
+// Package strength estimates how hard a password is to guess, in the manner
+// of zxcvbn: it finds dictionary words, keyboard patterns, repeats,
+// sequences and dates, then searches for the cheapest way an attacker could
+// assemble the password from them.
+package strength
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Scores, from "too guessable" to "very unguessable".
+const (
+	VeryWeak = iota
+	Weak
+	Fair
+	Strong
+	VeryStrong
+)
+
+// OfflineRate is the guesses per second assumed for the crack time: an
+// offline attack against a slow hash such as bcrypt or scrypt.
+const OfflineRate = 1e4
+
+// maxAnalyzed bounds the work done on very long passwords. Characters past
+// it are left out, so the estimate for such a password errs low: counting
+// them as brute force would rate a long run of one letter very strong.
+// Patterns are matched over everything before it.
+const maxAnalyzed = 256
+
+// Result is the estimate for one password.
+type Result struct {
+	Guesses     float64 // expected guesses to find the password
+	Score       int     // VeryWeak to VeryStrong
+	Sequence    []Match // the cheapest decomposition found
+	Warning     string
+	Suggestions []string
+}
+
+// Log10 returns the order of magnitude of the guess count.
+func (r Result) Log10() float64 {
+	return math.Log10(r.Guesses)
+}
+
+// CrackSeconds is how long an offline attacker needs at OfflineRate.
+func (r Result) CrackSeconds() float64 {
+	return r.Guesses / OfflineRate
+}
+
+// CrackTime renders CrackSeconds for people.
+func (r Result) CrackTime() string {
+	return DisplayTime(r.CrackSeconds())
+}
+
+// Label names the score.
+func (r Result) Label() string {
+	return [...]string{"very weak", "weak", "fair", "strong", "very strong"}[r.Score]
+}
+
+// String is a one-line summary for listings.
+func (r Result) String() string {
+	return fmt.Sprintf("%d/4 %s (10^%.1f guesses, cracked offline in %s)",
+		r.Score, r.Label(), r.Log10(), r.CrackTime())
+}
+
+// Estimate rates password. userInputs are strings an attacker would try
+// first, such as the entry's label, username and site.
+func Estimate(password string, userInputs ...string) Result {
+	runes := []rune(password)
+	if len(runes) > maxAnalyzed {
+		runes = runes[:maxAnalyzed]
+	}
+
+	dicts := dictionaries()
+	if inputs := userDictionary(userInputs); inputs != nil {
+		dicts = append(append([]dictionary(nil), dicts...), *inputs)
+	}
+	logG, seq := search(runes, allMatches(runes, dicts))
+
+	r := Result{Guesses: math.Pow(10, logG), Sequence: seq, Score: score(logG)}
+	r.Warning, r.Suggestions = feedback(r.Score, seq)
+	return r
+}
+
+func score(logG float64) int {
+	const delta = 5
+	g := math.Pow(10, logG)
+	switch {
+	case g < 1e3+delta:
+		return VeryWeak
+	case g < 1e6+delta:
+		return Weak
+	case g < 1e8+delta:
+		return Fair
+	case g < 1e10+delta:
+		return Strong
+	}
+	return VeryStrong
+}
+
+// minGuessesBeforeGrowing penalises decompositions with many parts: an
+// attacker trying l-part combinations has to get through the shorter ones
+// first. It is 10^4, kept as its logarithm.
+const logMinGuessesBeforeGrowing = 4
+
+type cell struct {
+	m     *Match
+	logPi float64 // log10 of the product of guesses up to here
+	logG  float64 // log10 of l!*pi + 10^(4(l-1))
+}
+
+// search finds the sequence of non-overlapping matches, with brute force
+// filling the gaps, that minimises the total guesses. It returns log10 of
+// that total and the sequence.
+func search(runes []rune, matches []*Match) (float64, []Match) {
+	n := len(runes)
+	if n == 0 {
+		return 0, nil
+	}
+	byEnd := make([][]*Match, n)
+	for _, m := range matches {
+		m.Guesses = guesses(m, n)
+		byEnd[m.J] = append(byEnd[m.J], m)
+	}
+
+	opt := make([]map[int]cell, n)
+	for k := range opt {
+		opt[k] = make(map[int]cell)
+	}
+	update := func(m *Match, l int) {
+		k := m.J
+		logPi := math.Log10(m.Guesses)
+		if l > 1 {
+			logPi += opt[m.I-1][l-1].logPi
+		}
+		logG := logAdd(logFactorial(l)+logPi, float64(l-1)*logMinGuessesBeforeGrowing)
+		for cl, c := range opt[k] {
+			if cl <= l && c.logG <= logG {
+				return
+			}
+		}
+		opt[k][l] = cell{m, logPi, logG}
+	}
+
+	for k := 0; k < n; k++ {
+		for _, m := range byEnd[k] {
+			if m.I == 0 {
+				update(m, 1)
+				continue
+			}
+			for l := range opt[m.I-1] {
+				update(m, l+1)
+			}
+		}
+		update(bruteforce(runes, 0, k), 1)
+		for i := 1; i <= k; i++ {
+			m := bruteforce(runes, i, k)
+			for l, c := range opt[i-1] {
+				// Adjacent brute force runs are one run.
+				if c.m.Pattern == Bruteforce {
+					continue
+				}
+				update(m, l+1)
+			}
+		}
+	}
+
+	bestL, best := 0, math.Inf(1)
+	for l, c := range opt[n-1] {
+		if c.logG < best || c.logG == best && l < bestL {
+			bestL, best = l, c.logG
+		}
+	}
+	seq := make([]Match, bestL)
+	for k, l := n-1, bestL; l > 0; l-- {
+		m := opt[k][l].m
+		seq[l-1] = *m
+		k = m.I - 1
+	}
+	return best, seq
+}
+
+func bruteforce(runes []rune, i, j int) *Match {
+	m := &Match{Pattern: Bruteforce, I: i, J: j, Token: string(runes[i : j+1])}
+	m.Guesses = guesses(m, len(runes))
+	return m
+}
+
+func logAdd(a, b float64) float64 {
+	if a < b {
+		a, b = b, a
+	}
+	return a + math.Log10(1+math.Pow(10, b-a))
+}
+
+func logFactorial(n int) float64 {
+	lg, _ := math.Lgamma(float64(n + 1))
+	return lg / math.Ln10
+}
+
+// DisplayTime renders a duration in seconds the way zxcvbn does.
+func DisplayTime(seconds float64) string {
+	const (
+		minute  = 60
+		hour    = minute * 60
+		day     = hour * 24
+		month   = day * 31
+		year    = month * 12
+		century = year * 100
+	)
+	unit := func(n float64, name string) string {
+		v := math.Round(n)
+		if v == 1 {
+			return "1 " + name
+		}
+		return fmt.Sprintf("%.0f %ss", v, name)
+	}
+	switch {
+	case seconds < 1:
+		return "less than a second"
+	case seconds < minute:
+		return unit(seconds, "second")
+	case seconds < hour:
+		return unit(seconds/minute, "minute")
+	case seconds < day:
+		return unit(seconds/hour, "hour")
+	case seconds < month:
+		return unit(seconds/day, "day")
+	case seconds < year:
+		return unit(seconds/month, "month")
+	case seconds < century:
+		return unit(seconds/year, "year")
+	}
+	return "centuries"
+}
+
+// feedback explains a weak score by the longest match in the sequence.
+func feedback(score int, seq []Match) (string, []string) {
+	if len(seq) == 0 {
+		return "", []string{
+			"Use a few words, avoid common phrases",
+			"No need for symbols, digits, or uppercase letters",
+		}
+	}
+	if score > Fair {
+		return "", nil
+	}
+	longest := seq[0]
+	for _, m := range seq[1:] {
+		if len([]rune(m.Token)) > len([]rune(longest.Token)) {
+			longest = m
+		}
+	}
+	warning, suggestions := longest.feedback(len(seq) == 1)
+	return warning, append([]string{"Add another word or two. Uncommon words are better."}, suggestions...)
+}
+
+func (m Match) feedback(sole bool) (string, []string) {
+	switch m.Pattern {
+	case Dictionary:
+		return m.dictionaryFeedback(sole)
+	case Spatial:
+		warning := "Short keyboard patterns are easy to guess"
+		if m.Turns == 1 {
+			warning = "Straight rows of keys are easy to guess"
+		}
+		return warning, []string{"Use a longer keyboard pattern with more turns"}
+	case Repeat:
+		warning := `Repeats like "abcabcabc" are only slightly harder to guess than "abc"`
+		if len([]rune(m.Base)) == 1 {
+			warning = `Repeats like "aaa" are easy to guess`
+		}
+		return warning, []string{"Avoid repeated words and characters"}
+	case Sequence:
+		return "Sequences like abc or 6543 are easy to guess", []string{"Avoid sequences"}
+	case Year:
+		return "Recent years are easy to guess", []string{"Avoid recent years", "Avoid years that are associated with you"}
+	case Date:
+		return "Dates are often easy to guess", []string{"Avoid dates and years that are associated with you"}
+	}
+	return "", nil
+}
+
+func (m Match) dictionaryFeedback(sole bool) (string, []string) {
+	var warning string
+	switch m.Dict {
+	case "passwords":
+		switch {
+		case sole && !m.L33t && !m.Reversed && m.Rank <= 10:
+			warning = "This is a top-10 common password"
+		case sole && !m.L33t && !m.Reversed && m.Rank <= 100:
+			warning = "This is a top-100 common password"
+		case sole && !m.L33t && !m.Reversed:
+			warning = "This is a very common password"
+		case math.Log10(m.Guesses) <= 4:
+			warning = "This is similar to a commonly used password"
+		}
+	case "english", "diceware":
+		if sole {
+			warning = "A word by itself is easy to guess"
+		}
+	case "names":
+		warning = "Common names and surnames are easy to guess"
+		if sole {
+			warning = "Names and surnames by themselves are easy to guess"
+		}
+	case "user_inputs":
+		warning = "This contains the entry's own label, username or site"
+	}
+
+	var suggestions []string
+	runes := []rune(m.Token)
+	switch lower := strings.ToLower(m.Token); {
+	case m.Token == strings.ToUpper(m.Token) && m.Token != lower:
+		suggestions = append(suggestions, "All-uppercase is almost as easy to guess as all-lowercase")
+	case len(runes) > 0 && string(runes[1:]) == strings.ToLower(string(runes[1:])) && m.Token != lower:
+		suggestions = append(suggestions, "Capitalization doesn't help very much")
+	}
+	if m.Reversed && len(runes) >= 4 {
+		suggestions = append(suggestions, "Reversed words aren't much harder to guess")
+	}
+	if m.L33t {
+		suggestions = append(suggestions, "Predictable substitutions like '@' instead of 'a' don't help very much")
+	}
+	return warning, suggestions
+}
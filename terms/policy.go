@@ -22,7 +22,7 @@ func DisplayAgreement() {
 
 	// Show terms
 	fmt.Println("\nROOK SECURITY POLICY & TERMS")
-	fmt.Println(consts.TERMS)
+	fmt.Print(consts.TERMS)
 
 	// Get initial terminal state
 	oldState, err := term.GetState(int(os.Stdin.Fd()))
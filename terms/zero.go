@@ -4,12 +4,15 @@ import (
   "fmt"
   "os"
   "math/rand"
+  "path/filepath"
   
   "github.com/mbbgs/rook/consts"
+  "github.com/mbbgs/rook/utils"
 )
 
 func NukeFiles() {
-    files := []string{consts.SECRET_ROOK, consts.STORE_FILE_PATH, consts.ATTEMPTS_PATH}
+    files := []string{consts.SECRET_ROOK, consts.STORE_FILE_PATH, consts.ATTEMPTS_PATH, consts.ANCHOR_PATH}
+    files = append(files, SchemaSnapshots()...)
 
     for _, file := range files {
         if _, err := os.Stat(file); os.IsNotExist(err) {
@@ -25,6 +28,18 @@ func NukeFiles() {
     os.Exit(1)
 }
 
+// SchemaSnapshots lists the copies of the store taken before schema
+// upgrades. Each holds the user record and wrapped keys, so anything that
+// wipes the vault must remove them too.
+func SchemaSnapshots() []string {
+    dir, err := utils.GetSessionDir()
+    if err != nil {
+        return nil
+    }
+    matches, _ := filepath.Glob(filepath.Join(dir, consts.SCHEMA_BACKUP+".v*"))
+    return matches
+}
+
 func ShredAndDelete(path string, passes int) error {
     info, err := os.Stat(path)
     if err != nil {
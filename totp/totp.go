@@ -0,0 +1,125 @@
+// Package totp computes time-based one-time passwords (RFC 6238) from the
+// seeds stored with entries.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Key is a parsed TOTP seed.
+type Key struct {
+	Secret    []byte
+	Digits    int
+	Period    time.Duration
+	Algorithm string // SHA1, SHA256 or SHA512
+	Issuer    string
+	Account   string
+}
+
+// Parse accepts a base32 secret, as sites show it, or an otpauth://totp/
+// URI, as QR codes carry it.
+func Parse(s string) (Key, error) {
+	k := Key{Digits: 6, Period: 30 * time.Second, Algorithm: "SHA1"}
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(strings.ToLower(s), "otpauth://") {
+		secret, err := decodeSecret(s)
+		k.Secret = secret
+		return k, err
+	}
+
+	u, err := url.Parse(s)
+	if err != nil {
+		return k, err
+	}
+	if !strings.EqualFold(u.Host, "totp") {
+		return k, fmt.Errorf("totp: unsupported otpauth type %q", u.Host)
+	}
+	label := strings.TrimPrefix(u.Path, "/")
+	if issuer, account, ok := strings.Cut(label, ":"); ok {
+		k.Issuer, k.Account = issuer, strings.TrimSpace(account)
+	} else {
+		k.Account = label
+	}
+	q := u.Query()
+	if issuer := q.Get("issuer"); issuer != "" {
+		k.Issuer = issuer
+	}
+	if k.Secret, err = decodeSecret(q.Get("secret")); err != nil {
+		return k, err
+	}
+	if v := q.Get("digits"); v != "" {
+		if k.Digits, err = strconv.Atoi(v); err != nil || k.Digits < 6 || k.Digits > 10 {
+			return k, fmt.Errorf("totp: bad digits %q", v)
+		}
+	}
+	if v := q.Get("period"); v != "" {
+		p, err := strconv.Atoi(v)
+		if err != nil || p <= 0 {
+			return k, fmt.Errorf("totp: bad period %q", v)
+		}
+		k.Period = time.Duration(p) * time.Second
+	}
+	if v := q.Get("algorithm"); v != "" {
+		k.Algorithm = strings.ToUpper(v)
+		if k.hash() == nil {
+			return k, fmt.Errorf("totp: unsupported algorithm %q", v)
+		}
+	}
+	return k, nil
+}
+
+func decodeSecret(s string) ([]byte, error) {
+	s = strings.ToUpper(strings.NewReplacer(" ", "", "-", "").Replace(s))
+	s = strings.TrimRight(s, "=")
+	if s == "" {
+		return nil, errors.New("totp: empty secret")
+	}
+	secret, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(s)
+	if err != nil {
+		return nil, errors.New("totp: secret is not valid base32")
+	}
+	return secret, nil
+}
+
+func (k Key) hash() func() hash.Hash {
+	switch k.Algorithm {
+	case "SHA1", "":
+		return sha1.New
+	case "SHA256":
+		return sha256.New
+	case "SHA512":
+		return sha512.New
+	}
+	return nil
+}
+
+// Code returns the code valid at t and how long it stays valid.
+func (k Key) Code(t time.Time) (string, time.Duration) {
+	period := int64(k.Period / time.Second)
+	counter := t.Unix() / period
+	remaining := time.Duration(period-t.Unix()%period) * time.Second
+
+	mac := hmac.New(k.hash(), k.Secret)
+	mac.Write(binary.BigEndian.AppendUint64(nil, uint64(counter)))
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff
+
+	mod := uint64(1)
+	for range k.Digits {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", k.Digits, uint64(value)%mod), remaining
+}
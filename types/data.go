@@ -1,6 +1,11 @@
 package types
 
 import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"strings"
 	"time"
 )
 
@@ -11,5 +16,122 @@ type Data struct {
 	Lpassword  []byte    `json:"lpassword"`
 	Lurl       string    `json:"lurl"`
 	LastAccess time.Time `json:"last_access"`
+	// Changed is when the password was last set; zero for entries saved
+	// before it was recorded.
+	Changed time.Time `json:"changed"`
+	// History holds earlier passwords, newest first.
+	History []Revision `json:"history,omitempty"`
 	Owner			 string		 `json:"owner"`
-}
\ No newline at end of file
+
+	// Kind is empty for logins; other kinds keep their schema's values
+	// in Fields.
+	Kind   Kind     `json:"kind,omitempty"`
+	Notes  string   `json:"notes,omitempty"`
+	Tags   []string `json:"tags,omitempty"`
+	Folder string   `json:"folder,omitempty"` // slash-separated path, e.g. "Work/Email"
+	// URLs are sites besides Lurl the credentials are used on.
+	URLs   []string `json:"urls,omitempty"`
+	Fields []Field  `json:"fields,omitempty"`
+	// TOTP is a base32 secret or an otpauth:// URI.
+	TOTP string `json:"totp,omitempty"`
+	// Created and Modified are set by the store; zero on entries saved
+	// before they were recorded.
+	Created  time.Time `json:"created,omitzero"`
+	Modified time.Time `json:"modified,omitzero"`
+}
+
+// AllURLs returns Lurl, if set, followed by URLs.
+func (d Data) AllURLs() []string {
+	var out []string
+	if d.Lurl != "" && d.Lurl != "(Not Set)" {
+		out = append(out, d.Lurl)
+	}
+	return append(out, d.URLs...)
+}
+
+// CleanFolder normalizes a folder path: components are trimmed and empty
+// ones dropped, so " Work//Email/ " becomes "Work/Email".
+func CleanFolder(path string) string {
+	var parts []string
+	for _, p := range strings.Split(path, "/") {
+		if p = strings.TrimSpace(p); p != "" {
+			parts = append(parts, p)
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// InFolder reports whether folder is path or lies below it. Folders compare
+// without regard to case.
+func InFolder(folder, path string) bool {
+	folder, path = strings.ToLower(CleanFolder(folder)), strings.ToLower(CleanFolder(path))
+	return path == "" || folder == path || strings.HasPrefix(folder, path+"/")
+}
+
+// HasTag reports whether the entry has tag, ignoring case.
+func (d Data) HasTag(tag string) bool {
+	for _, t := range d.Tags {
+		if strings.EqualFold(t, strings.TrimSpace(tag)) {
+			return true
+		}
+	}
+	return false
+}
+
+// FieldType says how a custom field is shown and checked.
+type FieldType string
+
+const (
+	FieldText   FieldType = "text"
+	FieldHidden FieldType = "hidden" // masked in listings, like a password
+	FieldURL    FieldType = "url"
+	FieldEmail  FieldType = "email"
+)
+
+// ParseFieldType accepts a field type name; an empty name means text.
+func ParseFieldType(s string) (FieldType, error) {
+	switch t := FieldType(strings.ToLower(strings.TrimSpace(s))); t {
+	case "":
+		return FieldText, nil
+	case FieldText, FieldHidden, FieldURL, FieldEmail:
+		return t, nil
+	}
+	return "", fmt.Errorf("unknown field type %q (want text, hidden, url or email)", s)
+}
+
+// Field is a named value stored with an entry, such as a security question
+// or a recovery code.
+type Field struct {
+	Name  string    `json:"name"`
+	Type  FieldType `json:"type"`
+	Value string    `json:"value"`
+}
+
+// Validate checks that the value suits the field's type.
+func (f Field) Validate() error {
+	if strings.TrimSpace(f.Name) == "" {
+		return errors.New("field name cannot be empty")
+	}
+	switch f.Type {
+	case FieldURL:
+		u, err := url.Parse(f.Value)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("%s: %q is not a URL", f.Name, f.Value)
+		}
+	case FieldEmail:
+		if _, err := mail.ParseAddress(f.Value); err != nil {
+			return fmt.Errorf("%s: %q is not an email address", f.Name, f.Value)
+		}
+	case FieldText, FieldHidden:
+	default:
+		return fmt.Errorf("%s: unknown field type %q", f.Name, f.Type)
+	}
+	return nil
+}
+
+// Revision is a password an entry used to have.
+type Revision struct {
+	Password []byte    `json:"password"`
+	Set      time.Time `json:"set"`      // when it became the password; zero if unknown
+	Replaced time.Time `json:"replaced"` // when it stopped being the password
+}
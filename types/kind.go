@@ -0,0 +1,275 @@
+package types
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Kind is what an entry holds. Entries saved before kinds existed have
+// none and are logins.
+type Kind string
+
+const (
+	KindLogin    Kind = "login"
+	KindNote     Kind = "note"
+	KindCard     Kind = "card"
+	KindSSHKey   Kind = "ssh"
+	KindAPIToken Kind = "token"
+	KindDatabase Kind = "database"
+	KindIdentity Kind = "identity"
+)
+
+// Kinds lists every kind in the order they are offered.
+var Kinds = []Kind{KindLogin, KindNote, KindCard, KindSSHKey, KindAPIToken, KindDatabase, KindIdentity}
+
+// ParseKind accepts a kind name or a common alias; an empty name means
+// login.
+func ParseKind(s string) (Kind, error) {
+	switch k := Kind(strings.ToLower(strings.TrimSpace(s))); k {
+	case "", "password", "web":
+		return KindLogin, nil
+	case "notes", "secure-note":
+		return KindNote, nil
+	case "credit-card", "payment":
+		return KindCard, nil
+	case "ssh-key", "sshkey", "key":
+		return KindSSHKey, nil
+	case "api", "api-token", "bearer":
+		return KindAPIToken, nil
+	case "db":
+		return KindDatabase, nil
+	case "id", "document":
+		return KindIdentity, nil
+	default:
+		for _, known := range Kinds {
+			if k == known {
+				return k, nil
+			}
+		}
+	}
+	names := make([]string, len(Kinds))
+	for i, k := range Kinds {
+		names[i] = string(k)
+	}
+	return "", fmt.Errorf("unknown kind %q (want %s)", s, strings.Join(names, ", "))
+}
+
+// Title is the kind's name as shown to people.
+func (k Kind) Title() string {
+	return k.Spec().Title
+}
+
+// HasPassword reports whether entries of the kind keep a password in
+// Lpassword, so that strength, breach and history checks apply.
+func (k Kind) HasPassword() bool {
+	return k.Spec().Credentials
+}
+
+// FieldSpec describes one field of a kind's schema. Values are stored in
+// Data.Fields under Name.
+type FieldSpec struct {
+	Name      string
+	Type      FieldType
+	Required  bool
+	Multiline bool   // read until an empty line, as for PEM keys
+	Hint      string // shown in the prompt
+	Check     func(string) error
+}
+
+// KindSpec is the schema of a kind.
+type KindSpec struct {
+	Kind  Kind
+	Title string
+	// Credentials means the entry has a username and password in Lname
+	// and Lpassword.
+	Credentials bool
+	Fields      []FieldSpec
+}
+
+var specs = map[Kind]KindSpec{
+	KindLogin: {Kind: KindLogin, Title: "Login", Credentials: true},
+	KindNote:  {Kind: KindNote, Title: "Secure note"},
+	KindCard: {Kind: KindCard, Title: "Payment card", Fields: []FieldSpec{
+		{Name: "Cardholder", Type: FieldText, Required: true},
+		{Name: "Number", Type: FieldHidden, Required: true, Check: CheckCardNumber},
+		{Name: "Expiry", Type: FieldText, Required: true, Hint: "MM/YY", Check: checkExpiry},
+		{Name: "CVV", Type: FieldHidden, Check: digits(3, 4)},
+		{Name: "PIN", Type: FieldHidden, Check: digits(4, 12)},
+	}},
+	KindSSHKey: {Kind: KindSSHKey, Title: "SSH key", Fields: []FieldSpec{
+		{Name: "Private key", Type: FieldHidden, Required: true, Multiline: true, Hint: "paste the PEM block", Check: checkPrivateKey},
+		{Name: "Public key", Type: FieldText, Hint: "authorized_keys line", Check: checkPublicKey},
+		{Name: "Passphrase", Type: FieldHidden},
+	}},
+	KindAPIToken: {Kind: KindAPIToken, Title: "API token", Fields: []FieldSpec{
+		{Name: "Token", Type: FieldHidden, Required: true},
+		{Name: "Endpoint", Type: FieldURL},
+		{Name: "Scopes", Type: FieldText},
+		{Name: "Expires", Type: FieldText, Hint: "YYYY-MM-DD", Check: checkDate},
+	}},
+	KindDatabase: {Kind: KindDatabase, Title: "Database", Credentials: true, Fields: []FieldSpec{
+		{Name: "Engine", Type: FieldText, Hint: "postgres, mysql, ..."},
+		{Name: "Host", Type: FieldText, Required: true},
+		{Name: "Port", Type: FieldText, Check: checkPort},
+		{Name: "Database", Type: FieldText},
+	}},
+	KindIdentity: {Kind: KindIdentity, Title: "Identity document", Fields: []FieldSpec{
+		{Name: "Document", Type: FieldText, Required: true, Hint: "passport, driving licence, ..."},
+		{Name: "Number", Type: FieldHidden, Required: true},
+		{Name: "Full name", Type: FieldText},
+		{Name: "Country", Type: FieldText},
+		{Name: "Issued", Type: FieldText, Hint: "YYYY-MM-DD", Check: checkDate},
+		{Name: "Expires", Type: FieldText, Hint: "YYYY-MM-DD", Check: checkDate},
+	}},
+}
+
+// Spec returns the schema of k; unknown kinds get the login schema.
+func (k Kind) Spec() KindSpec {
+	if spec, ok := specs[k]; ok {
+		return spec
+	}
+	return specs[KindLogin]
+}
+
+// EntryKind is the entry's kind, defaulting to login.
+func (d Data) EntryKind() Kind {
+	if d.Kind == "" {
+		return KindLogin
+	}
+	return d.Kind
+}
+
+// Field returns the value of the named field, or "".
+func (d Data) Field(name string) string {
+	for _, f := range d.Fields {
+		if f.Name == name {
+			return f.Value
+		}
+	}
+	return ""
+}
+
+// ValidateKind checks the entry against its kind's schema.
+func (d Data) ValidateKind() error {
+	spec := d.EntryKind().Spec()
+	if d.EntryKind() == KindNote && strings.TrimSpace(d.Notes) == "" {
+		return errors.New("a secure note needs some text")
+	}
+	for _, fs := range spec.Fields {
+		if err := fs.Validate(d.Field(fs.Name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Validate checks one value against the field's schema.
+func (fs FieldSpec) Validate(value string) error {
+	if value == "" {
+		if fs.Required {
+			return fmt.Errorf("%s is required", fs.Name)
+		}
+		return nil
+	}
+	if err := (Field{Name: fs.Name, Type: fs.Type, Value: value}).Validate(); err != nil {
+		return err
+	}
+	if fs.Check != nil {
+		if err := fs.Check(value); err != nil {
+			return fmt.Errorf("%s: %w", fs.Name, err)
+		}
+	}
+	return nil
+}
+
+// CardDigits strips the spaces and dashes people type in card numbers.
+func CardDigits(number string) string {
+	return strings.NewReplacer(" ", "", "-", "").Replace(number)
+}
+
+// CheckCardNumber applies the Luhn checksum.
+func CheckCardNumber(number string) error {
+	n := CardDigits(number)
+	if len(n) < 12 || len(n) > 19 {
+		return errors.New("card numbers have 12 to 19 digits")
+	}
+	sum := 0
+	for i := range len(n) {
+		c := n[len(n)-1-i]
+		if c < '0' || c > '9' {
+			return errors.New("card numbers are digits only")
+		}
+		v := int(c - '0')
+		if i%2 == 1 {
+			if v *= 2; v > 9 {
+				v -= 9
+			}
+		}
+		sum += v
+	}
+	if sum%10 != 0 {
+		return errors.New("not a valid card number (checksum failed)")
+	}
+	return nil
+}
+
+// CardExpiry parses MM/YY or MM/YYYY and returns the first instant the
+// card is no longer valid.
+func CardExpiry(s string) (time.Time, error) {
+	month, year, ok := strings.Cut(strings.TrimSpace(s), "/")
+	m, err1 := strconv.Atoi(month)
+	y, err2 := strconv.Atoi(year)
+	if !ok || err1 != nil || err2 != nil || m < 1 || m > 12 || (len(year) != 2 && len(year) != 4) {
+		return time.Time{}, fmt.Errorf("%q is not MM/YY", s)
+	}
+	if len(year) == 2 {
+		y += 2000
+	}
+	return time.Date(y, time.Month(m)+1, 1, 0, 0, 0, 0, time.Local), nil
+}
+
+func checkExpiry(s string) error {
+	_, err := CardExpiry(s)
+	return err
+}
+
+func checkDate(s string) error {
+	if _, err := time.Parse(time.DateOnly, s); err != nil {
+		return fmt.Errorf("%q is not YYYY-MM-DD", s)
+	}
+	return nil
+}
+
+func checkPort(s string) error {
+	if p, err := strconv.Atoi(s); err != nil || p < 1 || p > 65535 {
+		return fmt.Errorf("%q is not a port number", s)
+	}
+	return nil
+}
+
+func digits(lo, hi int) func(string) error {
+	return func(s string) error {
+		if len(s) < lo || len(s) > hi || strings.Trim(s, "0123456789") != "" {
+			return fmt.Errorf("expected %d to %d digits", lo, hi)
+		}
+		return nil
+	}
+}
+
+func checkPrivateKey(s string) error {
+	if !strings.Contains(s, "-----BEGIN") || !strings.Contains(s, "PRIVATE KEY-----") {
+		return errors.New("expected a PEM private key block")
+	}
+	return nil
+}
+
+func checkPublicKey(s string) error {
+	algo, rest, _ := strings.Cut(strings.TrimSpace(s), " ")
+	if rest == "" || !(strings.HasPrefix(algo, "ssh-") || strings.HasPrefix(algo, "ecdsa-") || strings.HasPrefix(algo, "sk-")) {
+		return errors.New("expected an authorized_keys line such as \"ssh-ed25519 AAAA...\"")
+	}
+	return nil
+}
@@ -3,12 +3,10 @@ package utils
 
 import (
     "os"
-    "crypto/rand"
     "fmt"
     "path/filepath"
     "strings"
     "unicode"
-    "math/big"
 )
 
 
@@ -71,23 +69,3 @@ func ValidatePassword(pw string) error {
 
     return nil
 }
-
-
-
-
-func Generate(length int) (string, error) {
-	srcString := "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdef0123456789"
-	srcLen := int64(len(srcString))
-
-	var result strings.Builder
-	for i := 0; i < length; i++ {
-		// Generate a random number within the length of srcString
-		index, err := rand.Int(rand.Reader, big.NewInt(srcLen))
-		if err != nil {
-			return "", err
-		}
-		// Use index as the position in srcString
-		result.WriteByte(srcString[index.Int64()])
-	}
-	return result.String(), nil
-}
\ No newline at end of file
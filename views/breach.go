@@ -0,0 +1,73 @@
+package dashboard
+
+import (
+	"fmt"
+
+	"github.com/mbbgs/rook/breach"
+	"github.com/mbbgs/rook/types"
+)
+
+// breaches opens the configured breach data on first use. It returns nil
+// when none is configured or it cannot be opened.
+func (d *Dashboard) breaches() *breach.Dataset {
+	if !d.pwnedOpened {
+		d.pwnedOpened = true
+		pwned, err := breach.OpenConfigured()
+		if err != nil {
+			fmt.Println("Breach check unavailable:", err)
+		}
+		d.pwned = pwned
+	}
+	return d.pwned
+}
+
+// breachCount returns how often password appears in the breach data, or 0
+// if it does not or no data is configured.
+func (d *Dashboard) breachCount(password []byte) int {
+	pwned := d.breaches()
+	if pwned == nil {
+		return 0
+	}
+	count, err := pwned.Count(password)
+	if err != nil {
+		fmt.Println("Breach check failed:", err)
+		return 0
+	}
+	return count
+}
+
+func breachNote(count int) string {
+	if count == 1 {
+		return "\033[1;31mfound once in known data breaches\033[0m"
+	}
+	return fmt.Sprintf("\033[1;31mfound %d times in known data breaches\033[0m", count)
+}
+
+// warnBreached prints, once per session start, how many entries use a
+// password found in the breach data.
+func (d *Dashboard) warnBreached() {
+	if d.breaches() == nil {
+		return
+	}
+	entries, err := d.storage.GetAllForUser(d.user.Username)
+	if err != nil {
+		return
+	}
+	n := 0
+	for _, data := range entries {
+		if d.entryBreached(data) > 0 {
+			n++
+		}
+	}
+	if n > 0 {
+		fmt.Printf("\033[1;31m%d of %d entries use a password found in known data breaches. Run health for details.\033[0m\n", n, len(entries))
+	}
+}
+
+// entryBreached is breachCount for an entry.
+func (d *Dashboard) entryBreached(data types.Data) int {
+	if !data.EntryKind().HasPassword() {
+		return 0
+	}
+	return d.breachCount(data.Lpassword)
+}
@@ -0,0 +1,207 @@
+package dashboard
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/mbbgs/rook/clipboard"
+	"github.com/mbbgs/rook/totp"
+	"github.com/mbbgs/rook/types"
+)
+
+const copyUsage = "Usage: copy <label> [--field password|user|url|totp|notes|<field>] | copy --timeout [seconds]"
+
+// clearAfter is how long copied values stay on the clipboard; zero means
+// until replaced.
+func (d *Dashboard) clearAfter() time.Duration {
+	switch secs := d.user.ClipboardClearSeconds; {
+	case secs < 0:
+		return 0
+	case secs == 0:
+		return clipboard.DefaultClearAfter
+	default:
+		return time.Duration(secs) * time.Second
+	}
+}
+
+// copyField handles "copy <label> [--field <field>]" and "copy --timeout
+// [seconds]". Everything before --field is the label, spaces and all.
+// Without the flag a field may still follow the label, which is then
+// split off as for tag.
+func (d *Dashboard) copyField(arg string) {
+	fields := strings.Fields(arg)
+	if len(fields) == 0 {
+		fmt.Println(copyUsage)
+		return
+	}
+	if fields[0] == "--timeout" {
+		d.clipboardTimeout(fields[1:])
+		return
+	}
+
+	var label, field string
+	if i := slices.Index(fields, "--field"); i >= 0 {
+		label, field = unquote(strings.Join(fields[:i], " ")), strings.Join(fields[i+1:], " ")
+		if field == "" {
+			fmt.Println(copyUsage)
+			return
+		}
+	} else {
+		var err error
+		if label, field, err = d.splitLabel(arg, false); err != nil {
+			fmt.Println(err)
+			return
+		}
+	}
+	if label == "" {
+		fmt.Println(copyUsage)
+		return
+	}
+	data, err := d.storage.GetByLabel(d.user.Username, types.Label(label))
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		fmt.Println("No entry found for label:", label)
+		return
+	}
+	if err != nil {
+		fmt.Println("Failed to get entry:", err)
+		return
+	}
+	name, value, err := pickField(data, field)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	how, err := d.toClipboard(value)
+	if err != nil {
+		fmt.Println("Failed to copy:", err)
+		return
+	}
+	fmt.Printf("Copied the %s of [%s] %s.\n", name, label, how)
+	if err := d.storage.Touch(d.user.Username, types.Label(label)); err != nil {
+		fmt.Println("Failed to record access:", err)
+	}
+}
+
+// toClipboard copies value, opening the clipboard on first use, and
+// describes how it was copied and how long it stays.
+func (d *Dashboard) toClipboard(value string) (string, error) {
+	if d.clip == nil {
+		var err error
+		if d.clip, err = clipboard.Open(os.Stdout); err != nil {
+			return "", err
+		}
+	}
+	keep := d.clearAfter()
+	if err := d.clip.Copy(value, keep); err != nil {
+		return "", err
+	}
+	clears := "it stays until replaced"
+	if keep > 0 {
+		clears = fmt.Sprintf("it is cleared in %s", keep)
+	}
+	return fmt.Sprintf("with %s; %s", d.clip.Method(), clears), nil
+}
+
+// pickField finds the value copy puts on the clipboard. With no field
+// named it is the password, or for kinds without one the note or first
+// hidden field, such as a card number or a private key.
+func pickField(data types.Data, field string) (name, value string, err error) {
+	kind := data.EntryKind()
+	if field == "" {
+		switch {
+		case kind.HasPassword():
+			field = "password"
+		case kind == types.KindNote:
+			field = "notes"
+		default:
+			for _, f := range kind.Spec().Fields {
+				if f.Type == types.FieldHidden && data.Field(f.Name) != "" {
+					field = f.Name
+					break
+				}
+			}
+			if field == "" {
+				return "", "", errors.New("the entry has nothing to copy; name a field")
+			}
+		}
+	}
+
+	switch strings.ToLower(field) {
+	case "password", "pass":
+		if !kind.HasPassword() {
+			return "", "", fmt.Errorf("a %s has no password", kind.Title())
+		}
+		name, value = "password", string(data.Lpassword)
+	case "user", "username":
+		name, value = "username", data.Lname
+	case "url":
+		if urls := data.AllURLs(); len(urls) > 0 {
+			name, value = "URL", urls[0]
+		}
+	case "totp", "otp", "code":
+		if data.TOTP == "" {
+			return "", "", errors.New("the entry has no TOTP seed")
+		}
+		key, err := totp.Parse(data.TOTP)
+		if err != nil {
+			return "", "", err
+		}
+		code, _ := key.Code(time.Now())
+		name, value = "TOTP code", code
+	case "notes":
+		name, value = "notes", data.Notes
+	default:
+		for _, f := range data.Fields {
+			if strings.EqualFold(f.Name, field) {
+				name, value = f.Name, f.Value
+				break
+			}
+		}
+		if name == "" {
+			return "", "", fmt.Errorf("the entry has no field %q", field)
+		}
+	}
+	if value == "" {
+		return "", "", fmt.Errorf("the %s of the entry is empty", cmp.Or(name, field))
+	}
+	return name, value, nil
+}
+
+func (d *Dashboard) clipboardTimeout(args []string) {
+	if len(args) == 0 {
+		if keep := d.clearAfter(); keep > 0 {
+			fmt.Printf("Copied values are cleared from the clipboard after %s.\n", keep)
+		} else {
+			fmt.Println("Copied values stay on the clipboard until replaced.")
+		}
+		return
+	}
+	secs, err := strconv.Atoi(args[0])
+	if err != nil || secs < 0 {
+		fmt.Println(copyUsage)
+		return
+	}
+	if secs == 0 {
+		secs = -1 // zero in the user record means the default
+	}
+	user, err := d.storage.GetUser()
+	if err != nil {
+		fmt.Println("Failed to save setting:", err)
+		return
+	}
+	user.ClipboardClearSeconds = secs
+	if err := d.storage.UpdateUser(user); err != nil {
+		fmt.Println("Failed to save setting:", err)
+		return
+	}
+	d.user.ClipboardClearSeconds = secs
+	d.clipboardTimeout(nil)
+}
@@ -0,0 +1,328 @@
+package dashboard
+
+import (
+	"cmp"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/mbbgs/rook/totp"
+	"github.com/mbbgs/rook/types"
+	"golang.org/x/crypto/ssh"
+)
+
+// promptDetails asks for the optional parts of an entry. Every prompt may
+// be skipped with Enter.
+func promptDetails(data *types.Data) {
+	if data.EntryKind() == types.KindLogin {
+		data.URLs = splitList(readLine("Other URLs, comma separated (optional): "))
+	}
+	data.Folder = types.CleanFolder(readLine("Folder, e.g. Work/Email (optional): "))
+	data.Tags = splitList(readLine("Tags, comma separated (optional): "))
+
+	for {
+		seed := readLine("TOTP secret or otpauth:// URI (optional): ")
+		if seed == "" {
+			break
+		}
+		if _, err := totp.Parse(seed); err != nil {
+			fmt.Println(err)
+			continue
+		}
+		data.TOTP = seed
+		break
+	}
+
+	if data.EntryKind() != types.KindNote {
+		data.Notes = readLines("Notes (optional, end with an empty line):")
+	}
+
+	for {
+		name := readLine("Custom field name (Enter to finish): ")
+		if name == "" {
+			return
+		}
+		kind, err := types.ParseFieldType(readLine("Type [text|hidden|url|email] (default text): "))
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		var value string
+		if kind == types.FieldHidden {
+			value = readSecret("Value: ")
+		} else {
+			value = readLine("Value: ")
+		}
+		f := types.Field{Name: name, Type: kind, Value: value}
+		if err := f.Validate(); err != nil {
+			fmt.Println(err)
+			continue
+		}
+		data.Fields = append(data.Fields, f)
+	}
+}
+
+// promptKindFields asks for the fields of the entry's kind, repeating a
+// prompt until its answer passes the schema's checks.
+func promptKindFields(data *types.Data) {
+	if data.EntryKind() == types.KindNote {
+		for data.Notes == "" {
+			data.Notes = readLines("Note text (end with an empty line):")
+		}
+	}
+	for _, fs := range data.EntryKind().Spec().Fields {
+		prompt := fs.Name
+		if fs.Hint != "" {
+			prompt += " (" + fs.Hint + ")"
+		}
+		if !fs.Required {
+			prompt += " (optional)"
+		}
+		for {
+			var value string
+			switch {
+			case fs.Multiline:
+				value = readLines(prompt + ", end with an empty line:")
+			case fs.Type == types.FieldHidden:
+				value = readSecret(prompt + ": ")
+			default:
+				value = readLine(prompt + ": ")
+			}
+			if err := fs.Validate(value); err != nil {
+				fmt.Println(err)
+				continue
+			}
+			if data.EntryKind() == types.KindCard && fs.Name == "Number" {
+				value = types.CardDigits(value)
+			}
+			if value != "" {
+				data.Fields = append(data.Fields, types.Field{Name: fs.Name, Type: fs.Type, Value: value})
+			}
+			break
+		}
+	}
+}
+
+// readLines reads lines until an empty one and joins them.
+func readLines(prompt string) string {
+	fmt.Println(prompt)
+	var lines []string
+	for {
+		line := readLine("")
+		if line == "" {
+			return strings.Join(lines, "\n")
+		}
+		lines = append(lines, line)
+	}
+}
+
+// splitList splits a comma separated answer, dropping blanks and
+// duplicates.
+func splitList(s string) []string {
+	var out []string
+	seen := map[string]bool{}
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" || seen[v] {
+			continue
+		}
+		seen[v] = true
+		out = append(out, v)
+	}
+	return out
+}
+
+// showEntry prints every part of an entry the way its kind reads best,
+// with the current TOTP code. Passwords and hidden fields are masked; copy
+// puts them on the clipboard instead.
+func showEntry(label string, data types.Data) {
+	kind := data.EntryKind()
+	if kind == types.KindLogin {
+		fmt.Printf("[%s]\n", label)
+	} else {
+		fmt.Printf("[%s] %s\n", label, kind.Title())
+	}
+	if kind == types.KindNote {
+		fmt.Printf("  %s\n", strings.ReplaceAll(data.Notes, "\n", "\n  "))
+	}
+	if kind.HasPassword() {
+		fmt.Printf("  User: %s\n  Password: %s (copy %s)\n", data.Lname, hidden, label)
+	}
+	if kind == types.KindDatabase {
+		fmt.Printf("  Connection: %s\n", connectionString(data))
+	}
+	if urls := data.AllURLs(); len(urls) > 0 {
+		fmt.Printf("  URL: %s\n", strings.Join(urls, "\n       "))
+	}
+	for _, f := range data.Fields {
+		value := f.Value
+		switch {
+		case f.Type == types.FieldHidden:
+			value = fmt.Sprintf("%s (copy %s %s)", hidden, label, strings.ToLower(f.Name))
+			if kind == types.KindCard && f.Name == "Number" {
+				value = "**** " + f.Value[max(0, len(f.Value)-4):]
+			}
+		case strings.Contains(value, "\n"):
+			value = "\n    " + strings.ReplaceAll(value, "\n", "\n    ")
+		}
+		fmt.Printf("  %s: %s%s\n", f.Name, value, fieldNote(kind, f))
+	}
+	if kind == types.KindSSHKey {
+		if fp := sshFingerprint(data.Field("Public key")); fp != "" {
+			fmt.Printf("  Fingerprint: %s\n", fp)
+		}
+	}
+	if data.Folder != "" {
+		fmt.Printf("  Folder: %s\n", data.Folder)
+	}
+	if len(data.Tags) > 0 {
+		fmt.Printf("  Tags: %s\n", strings.Join(data.Tags, ", "))
+	}
+	if data.TOTP != "" {
+		fmt.Printf("  TOTP: %s\n", totpNote(data.TOTP))
+	}
+	if data.Notes != "" && kind != types.KindNote {
+		fmt.Printf("  Notes:\n    %s\n", strings.ReplaceAll(data.Notes, "\n", "\n    "))
+	}
+	fmt.Printf("  Created: %s\n  Modified: %s\n", formatWhen(data.Created), formatWhen(data.Modified))
+	if kind.HasPassword() {
+		fmt.Printf("  Password changed: %s\n", formatWhen(data.Changed))
+	}
+	fmt.Printf("  Last access: %s\n", formatWhen(data.LastAccess))
+}
+
+// hidden stands in for secrets get does not print.
+const hidden = "********"
+
+// fieldNote is what get adds after a schema field: a card's brand, or
+// whether a date has passed.
+func fieldNote(kind types.Kind, f types.Field) string {
+	var expires time.Time
+	switch {
+	case kind == types.KindCard && f.Name == "Number":
+		if brand := cardBrand(f.Value); brand != "" {
+			return " (" + brand + ")"
+		}
+		return ""
+	case kind == types.KindCard && f.Name == "Expiry":
+		expires, _ = types.CardExpiry(f.Value)
+	case f.Name == "Expires" && kind != types.KindLogin:
+		expires, _ = time.ParseInLocation(time.DateOnly, f.Value, time.Local)
+	}
+	if !expires.IsZero() && time.Now().After(expires) {
+		return " (expired)"
+	}
+	return ""
+}
+
+// cardBrand guesses the network from the number's prefix.
+func cardBrand(number string) string {
+	n := types.CardDigits(number)
+	switch {
+	case strings.HasPrefix(n, "4"):
+		return "Visa"
+	case strings.HasPrefix(n, "34"), strings.HasPrefix(n, "37"):
+		return "American Express"
+	case len(n) >= 4 && (n[:2] >= "51" && n[:2] <= "55" || n[:4] >= "2221" && n[:4] <= "2720"):
+		return "Mastercard"
+	case strings.HasPrefix(n, "6011"), strings.HasPrefix(n, "65"):
+		return "Discover"
+	case strings.HasPrefix(n, "35"):
+		return "JCB"
+	}
+	return ""
+}
+
+// connectionString renders a database entry as a URL, without the
+// password.
+func connectionString(data types.Data) string {
+	u := url.URL{
+		Scheme: strings.ToLower(cmp.Or(data.Field("Engine"), "db")),
+		User:   url.User(data.Lname),
+		Host:   data.Field("Host"),
+		Path:   "/" + data.Field("Database"),
+	}
+	if port := data.Field("Port"); port != "" {
+		u.Host = net.JoinHostPort(u.Host, port)
+	}
+	if data.Lname == "" {
+		u.User = nil
+	}
+	return u.String()
+}
+
+// sshFingerprint is the SHA256 fingerprint of an authorized_keys line, or
+// "" if it does not parse.
+func sshFingerprint(public string) string {
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(public))
+	if err != nil {
+		return ""
+	}
+	return ssh.FingerprintSHA256(key)
+}
+
+// totpNote is the current code and how long it is valid for.
+func totpNote(seed string) string {
+	key, err := totp.Parse(seed)
+	if err != nil {
+		return "(invalid: " + err.Error() + ")"
+	}
+	code, left := key.Code(time.Now())
+	return fmt.Sprintf("%s (%ds left)", code, int(left.Seconds()))
+}
+
+// entrySummary is the extra detail list shows for an entry, one line each.
+// Hidden values are masked.
+func entrySummary(data types.Data) []string {
+	var lines []string
+	if len(data.URLs) > 0 {
+		lines = append(lines, fmt.Sprintf("Other URLs: %d", len(data.URLs)))
+	}
+	for _, f := range data.Fields {
+		value := f.Value
+		switch {
+		case data.EntryKind() == types.KindCard && f.Name == "Number":
+			value = "**** " + value[max(0, len(value)-4):]
+		case strings.Contains(value, "\n"):
+			value = "(set)"
+		case f.Type == types.FieldHidden:
+			value = maskPassword(value)
+		}
+		lines = append(lines, f.Name+": "+value+fieldNote(data.EntryKind(), f))
+	}
+	if data.Folder != "" {
+		lines = append(lines, "Folder: "+data.Folder)
+	}
+	if len(data.Tags) > 0 {
+		lines = append(lines, "Tags: "+strings.Join(data.Tags, ", "))
+	}
+	if data.TOTP != "" {
+		lines = append(lines, "TOTP: set")
+	}
+	if data.Notes != "" {
+		lines = append(lines, "Notes: yes")
+	}
+	if !data.Modified.IsZero() {
+		lines = append(lines, "Modified: "+formatWhen(data.Modified))
+	}
+	return lines
+}
+
+// addKind adds an entry of a kind without a password, such as a note or a
+// card.
+func (d *Dashboard) addKind(label string, kind types.Kind) {
+	data := types.Data{Kind: kind, Lurl: "(Not Set)"}
+	promptKindFields(&data)
+	promptDetails(&data)
+	if err := data.ValidateKind(); err != nil {
+		fmt.Println("Entry not saved:", err)
+		return
+	}
+	if err := d.storage.AddToStore(d.user.Username, types.Label(label), data); err != nil {
+		fmt.Println("Failed to add data:", err)
+		return
+	}
+	fmt.Println(kind.Title(), "added successfully.")
+}
@@ -0,0 +1,123 @@
+package dashboard
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/mbbgs/rook/backup"
+	"github.com/mbbgs/rook/interchange"
+	"github.com/mbbgs/rook/securecrypto"
+	"github.com/mbbgs/rook/utils"
+
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+const exportUsage = "Usage: export <bitwarden|csv|keepass|kdbx> <file> [--encrypt]"
+
+// exportData handles "export <format> <file> [--encrypt]".
+func (d *Dashboard) exportData(arg string) {
+	var rest []string
+	encrypt := false
+	for _, f := range strings.Fields(arg) {
+		if f == "--encrypt" || f == "-e" {
+			encrypt = true
+			continue
+		}
+		rest = append(rest, f)
+	}
+	if len(rest) < 2 {
+		fmt.Println(exportUsage)
+		return
+	}
+	format, err := interchange.ParseExportFormat(rest[0])
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	path := strings.Join(rest[1:], " ")
+	if utils.FileExists(path) {
+		fmt.Println("File already exists:", path)
+		return
+	}
+
+	password := readSecret("Re-enter your password to export: ")
+	if !securecrypto.VerifySecret(password, d.user.Password, d.user.PasswordKDF) {
+		fmt.Println("Incorrect password; nothing exported.")
+		return
+	}
+
+	var passphrase string
+	if encrypt && !format.Plaintext() {
+		fmt.Println("A KeePass database is already encrypted; drop --encrypt.")
+		return
+	}
+	if encrypt || !format.Plaintext() {
+		passphrase = readSecret("Choose a password for the export: ")
+		if len(passphrase) < 12 {
+			fmt.Println("Export password must be at least 12 characters.")
+			return
+		}
+		if readSecret("Confirm the export password: ") != passphrase {
+			fmt.Println("Passwords do not match.")
+			return
+		}
+	} else {
+		fmt.Println()
+		fmt.Println("\033[1;31m!!! WARNING: UNENCRYPTED EXPORT !!!\033[0m")
+		fmt.Println("\033[1;31mEvery password in the vault will be written to", path, "in plain text.\033[0m")
+		fmt.Println("\033[1;31mAnyone who can read that file, its backups or its deleted blocks can read them.\033[0m")
+		fmt.Println("Use --encrypt to seal the export with a passphrase instead.")
+		fmt.Print("Type EXPORT to continue: ")
+		var answer string
+		fmt.Scanln(&answer)
+		if answer != "EXPORT" {
+			fmt.Println("Export cancelled.")
+			return
+		}
+	}
+
+	entries, err := d.storage.GetAllForUser(d.user.Username)
+	if err != nil {
+		fmt.Println("Failed to list data:", err)
+		return
+	}
+	var buf bytes.Buffer
+	if err := interchange.Export(&buf, format, interchange.Records(entries), passphrase); err != nil {
+		fmt.Println("Failed to export:", err)
+		return
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		fmt.Println("Failed to create file:", err)
+		return
+	}
+	if encrypt {
+		err = backup.WriteFile(f, []byte(passphrase), "rook-export"+format.Extension(), buf.Bytes())
+	} else {
+		_, err = f.Write(buf.Bytes())
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(path)
+		fmt.Println("Failed to export:", err)
+		return
+	}
+	utils.Done(fmt.Sprintf("Exported %d entries to %s.", len(entries), path))
+}
+
+// readSecret prompts for input without echoing it.
+func readSecret(prompt string) string {
+	fmt.Print(prompt)
+	secret, err := terminal.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Println()
+	if err != nil {
+		utils.ErrorE(err)
+		return ""
+	}
+	return strings.TrimSpace(string(secret))
+}
@@ -0,0 +1,80 @@
+package dashboard
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/mbbgs/rook/search"
+	"github.com/mbbgs/rook/types"
+	"golang.org/x/term"
+)
+
+// maxResults caps how many matches find and search print.
+const maxResults = 20
+
+// find handles "find <query>".
+func (d *Dashboard) find(query string) {
+	entries, err := d.storage.GetAllForUser(d.user.Username)
+	if err != nil {
+		fmt.Println("Failed to list data:", err)
+		return
+	}
+	if PrintMatches(os.Stdout, query, entries) == 0 {
+		fmt.Println("No entries match", query)
+	}
+}
+
+// PrintMatches writes the entries matching query, best first, with the
+// matched characters highlighted when w is a terminal. It returns how many
+// entries matched.
+func PrintMatches(w io.Writer, query string, entries map[string]types.Data) int {
+	results := search.Search(query, entries)
+	on, off := "", ""
+	if f, ok := w.(*os.File); ok && term.IsTerminal(int(f.Fd())) {
+		on, off = "\033[1;33m", "\033[0m"
+	}
+
+	for i, r := range results {
+		if i == maxResults {
+			fmt.Fprintf(w, "... and %d more; refine the query.\n", len(results)-maxResults)
+			break
+		}
+		// Several terms may match the same field; mark them together.
+		marks := make(map[string][]int)
+		for _, h := range r.Hits {
+			key := h.Field.Name + "\x00" + h.Field.Text
+			marks[key] = append(marks[key], h.Positions...)
+		}
+		show := func(name, text string) string {
+			return search.Highlight(text, marks[name+"\x00"+text], on, off)
+		}
+
+		fmt.Fprintf(w, "[%s]", show("label", r.Label))
+		if kind := r.Data.EntryKind(); kind != types.KindLogin {
+			fmt.Fprintf(w, " %s", kind.Title())
+		}
+		fmt.Fprintln(w)
+		if r.Data.Lname != "" {
+			fmt.Fprintf(w, "  User: %s\n", show("user", r.Data.Lname))
+		}
+		if urls := r.Data.AllURLs(); len(urls) > 0 {
+			fmt.Fprintf(w, "  URL: %s\n", show("url", urls[0]))
+		}
+		// Matches outside the fields above are shown where they were found.
+		shown := map[string]bool{"label\x00" + r.Label: true, "user\x00" + r.Data.Lname: true}
+		if urls := r.Data.AllURLs(); len(urls) > 0 {
+			shown["url\x00"+urls[0]] = true
+		}
+		for _, h := range r.Hits {
+			key := h.Field.Name + "\x00" + h.Field.Text
+			if shown[key] {
+				continue
+			}
+			shown[key] = true
+			fmt.Fprintf(w, "  %s: %s\n", strings.ToUpper(h.Field.Name[:1])+h.Field.Name[1:], show(h.Field.Name, h.Field.Text))
+		}
+	}
+	return len(results)
+}
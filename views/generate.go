@@ -0,0 +1,190 @@
+package dashboard
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/mbbgs/rook/generator"
+)
+
+const generateUsage = `Usage: generate [--words N | --pronounceable] [options] [--show]
+  --show                   print the result instead of copying it
+  --length N               password length (default 20)
+  --no-lower, --no-upper, --no-digits, --no-symbols
+                           leave a character class out
+  --min-lower N, --min-upper N, --min-digits N, --min-symbols N
+                           minimum characters of a class (default 1)
+  --exclude CHARS          never use these characters
+  --allow-ambiguous        allow characters like O, 0, l, 1 and I
+  --pronounceable          alternate consonants and vowels
+  --words N                diceware passphrase of N words (EFF list)
+  --separator S            between passphrase words (default "-")
+  --capitalize MODE        none, first, all or random`
+
+// generatorOptions parses generate's flags into generator options.
+func generatorOptions(args []string) (generator.Options, error) {
+	o := generator.Defaults()
+	fs := flag.NewFlagSet("generate", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+
+	var noLower, noUpper, noDigits, noSymbols, pronounceable bool
+	var words int
+	var capitalize string
+	fs.IntVar(&o.Length, "length", o.Length, "")
+	fs.BoolVar(&noLower, "no-lower", false, "")
+	fs.BoolVar(&noUpper, "no-upper", false, "")
+	fs.BoolVar(&noDigits, "no-digits", false, "")
+	fs.BoolVar(&noSymbols, "no-symbols", false, "")
+	fs.IntVar(&o.MinLower, "min-lower", o.MinLower, "")
+	fs.IntVar(&o.MinUpper, "min-upper", o.MinUpper, "")
+	fs.IntVar(&o.MinDigits, "min-digits", o.MinDigits, "")
+	fs.IntVar(&o.MinSymbols, "min-symbols", o.MinSymbols, "")
+	fs.StringVar(&o.Exclude, "exclude", "", "")
+	fs.BoolVar(&o.AllowAmbiguous, "allow-ambiguous", false, "")
+	fs.BoolVar(&pronounceable, "pronounceable", false, "")
+	fs.IntVar(&words, "words", 0, "")
+	fs.StringVar(&o.Separator, "separator", o.Separator, "")
+	fs.StringVar(&capitalize, "capitalize", string(o.Capitalize), "")
+	if err := fs.Parse(args); err != nil {
+		return o, err
+	}
+	if fs.NArg() > 0 {
+		return o, fmt.Errorf("unexpected argument %q", fs.Arg(0))
+	}
+
+	o.Lower, o.Upper, o.Digits, o.Symbols = !noLower, !noUpper, !noDigits, !noSymbols
+	o.Capitalize = generator.Capitalization(strings.ToLower(capitalize))
+	switch {
+	case words > 0 && pronounceable:
+		return o, fmt.Errorf("--words and --pronounceable cannot be combined")
+	case words > 0:
+		o.Mode, o.Words = generator.Passphrase, words
+	case pronounceable:
+		o.Mode = generator.Pronounceable
+	}
+	return o, nil
+}
+
+// generate handles "generate [options] [--show]". The fresh secret goes to
+// the clipboard and is shown masked; only --show prints it.
+func (d *Dashboard) generate(arg string) {
+	show := false
+	var args []string
+	for _, f := range strings.Fields(arg) {
+		if f == "--show" {
+			show = true
+			continue
+		}
+		args = append(args, f)
+	}
+	o, err := generatorOptions(args)
+	if err != nil {
+		if !errors.Is(err, flag.ErrHelp) {
+			fmt.Println(err)
+		}
+		fmt.Println(generateUsage)
+		return
+	}
+	res, err := generator.Generate(o)
+	if err != nil {
+		fmt.Println("Failed to generate:", err)
+		return
+	}
+	if show {
+		fmt.Println(res.Password)
+	} else {
+		fmt.Println("Generated:", maskPassword(res.Password))
+		if how, err := d.toClipboard(res.Password); err != nil {
+			fmt.Println("Failed to copy:", err)
+			fmt.Println("Run generate --show to print it instead.")
+		} else {
+			fmt.Printf("Copied it %s.\n", how)
+		}
+	}
+	fmt.Println(entropyNote(res.Entropy))
+}
+
+// promptGenerated offers generated passwords until the user accepts one.
+// Each is shown masked, to be revealed or copied on request. It returns ""
+// if they give up.
+func (d *Dashboard) promptGenerated() string {
+	fmt.Println("Generator options (Enter for a 20-character password; e.g. --words 6, --length 32 --no-symbols):")
+	line := readLine("generate> ")
+	o, err := generatorOptions(strings.Fields(line))
+	if err != nil {
+		if !errors.Is(err, flag.ErrHelp) {
+			fmt.Println(err)
+		}
+		fmt.Println(generateUsage)
+		return ""
+	}
+	for {
+		res, err := generator.Generate(o)
+		if err != nil {
+			fmt.Println("Failed to generate:", err)
+			return ""
+		}
+		fmt.Println("Generated:", maskPassword(res.Password))
+		fmt.Println(entropyNote(res.Entropy))
+		if use, again := d.offerGenerated(res.Password); !again {
+			return use
+		}
+	}
+}
+
+// offerGenerated asks what to do with a generated password, revealing or
+// copying it as often as asked. It returns the password if it is taken,
+// or again if another is wanted.
+func (d *Dashboard) offerGenerated(password string) (use string, again bool) {
+	for {
+		switch strings.ToLower(readLine("Use it? [Y]es / [a]nother / [r]eveal / [c]opy / [n]o: ")) {
+		case "", "y", "yes":
+			return password, false
+		case "a", "another":
+			return "", true
+		case "r", "reveal":
+			fmt.Println("Generated:", password)
+		case "c", "copy":
+			if how, err := d.toClipboard(password); err != nil {
+				fmt.Println("Failed to copy:", err)
+			} else {
+				fmt.Printf("Copied it %s.\n", how)
+			}
+		default:
+			return "", false
+		}
+	}
+}
+
+func entropyNote(bits float64) string {
+	rating := "weak"
+	switch {
+	case bits >= 100:
+		rating = "excellent"
+	case bits >= 80:
+		rating = "strong"
+	case bits >= 60:
+		rating = "fair"
+	}
+	return fmt.Sprintf("Entropy: %.1f bits (%s)", bits, rating)
+}
+
+// readLine reads one line from stdin a byte at a time, so nothing past the
+// newline is taken from the dashboard's own scanner.
+func readLine(prompt string) string {
+	fmt.Print(prompt)
+	var sb strings.Builder
+	b := make([]byte, 1)
+	for {
+		n, err := os.Stdin.Read(b)
+		if n == 0 || err != nil || b[0] == '\n' {
+			break
+		}
+		sb.WriteByte(b[0])
+	}
+	return strings.TrimSpace(sb.String())
+}
@@ -0,0 +1,110 @@
+package dashboard
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mbbgs/rook/health"
+)
+
+const healthUsage = "Usage: health [--days N]"
+
+// healthReport handles "health [--days N]".
+func (d *Dashboard) healthReport(arg string) {
+	opts := health.Options{}
+	if d.breaches() != nil {
+		opts.Breached = d.breachCount
+	}
+	fields := strings.Fields(arg)
+	for i := 0; i < len(fields); i++ {
+		f := fields[i]
+		value, ok := strings.CutPrefix(f, "--days=")
+		if f == "--days" && i+1 < len(fields) {
+			i++
+			value, ok = fields[i], true
+		}
+		days, err := strconv.Atoi(value)
+		if !ok || err != nil || days <= 0 {
+			fmt.Println(healthUsage)
+			return
+		}
+		opts.MaxAge = time.Duration(days) * 24 * time.Hour
+	}
+
+	entries, err := d.storage.GetAllForUser(d.user.Username)
+	if err != nil {
+		fmt.Println("Failed to list data:", err)
+		return
+	}
+	if len(entries) == 0 {
+		fmt.Println("No saved entries for user", d.user.Username)
+		return
+	}
+	r := health.Check(entries, opts)
+	maxDays := int(health.DefaultMaxAge.Hours() / 24)
+	if opts.MaxAge > 0 {
+		maxDays = int(opts.MaxAge.Hours() / 24)
+	}
+
+	fmt.Printf("Vault health: %d/100 (%s) - %d entries, %d issues\n", r.Score, r.Grade(), r.Entries, r.Issues())
+
+	if len(r.Breached) > 0 {
+		sort.SliceStable(r.Breached, func(i, j int) bool { return r.Breached[i].Count > r.Breached[j].Count })
+		fmt.Printf("\nFound in known data breaches (%d) - change these first:\n", len(r.Breached))
+		for _, b := range r.Breached {
+			fmt.Printf("  - %s: seen %d times\n", b.Label, b.Count)
+		}
+	}
+	if len(r.Reused) > 0 {
+		fmt.Printf("\nReused passwords (%d groups):\n", len(r.Reused))
+		for _, group := range r.Reused {
+			fmt.Println("  -", strings.Join(group, ", "))
+		}
+	}
+	if len(r.Near) > 0 {
+		fmt.Printf("\nNear-duplicate passwords (%d):\n", len(r.Near))
+		for _, p := range r.Near {
+			edits := "differ only in case"
+			if p.Distance == 1 {
+				edits = "1 edit apart"
+			} else if p.Distance > 1 {
+				edits = fmt.Sprintf("%d edits apart", p.Distance)
+			}
+			fmt.Printf("  - %s ~ %s (%s)\n", p.A, p.B, edits)
+		}
+	}
+	if len(r.Weak) > 0 {
+		sort.SliceStable(r.Weak, func(i, j int) bool { return r.Weak[i].Result.Score < r.Weak[j].Result.Score })
+		fmt.Printf("\nWeak passwords (%d):\n", len(r.Weak))
+		for _, w := range r.Weak {
+			line := fmt.Sprintf("  - %s: %d/4 %s, cracked offline in %s", w.Label, w.Result.Score, w.Result.Label(), w.Result.CrackTime())
+			if w.Result.Warning != "" {
+				line += " - " + w.Result.Warning
+			}
+			fmt.Println(line)
+		}
+	}
+	if len(r.Stale) > 0 {
+		sort.SliceStable(r.Stale, func(i, j int) bool { return r.Stale[i].Age > r.Stale[j].Age })
+		fmt.Printf("\nNot changed in %d days (%d):\n", maxDays, len(r.Stale))
+		for _, s := range r.Stale {
+			fmt.Printf("  - %s: last changed %s (%d days ago)\n", s.Label, s.Changed.Format("2006-01-02"), int(s.Age.Hours()/24))
+		}
+	}
+	if len(r.MissingURL) > 0 {
+		fmt.Printf("\nMissing URL (%d):\n", len(r.MissingURL))
+		for _, label := range r.MissingURL {
+			fmt.Println("  -", label)
+		}
+	}
+	if len(r.Undated) > 0 {
+		fmt.Printf("\nNo change date recorded (%d); re-save them to start tracking:\n", len(r.Undated))
+		fmt.Println("  -", strings.Join(r.Undated, ", "))
+	}
+	if r.Issues() == 0 {
+		fmt.Println("No issues found.")
+	}
+}
@@ -0,0 +1,135 @@
+package dashboard
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mbbgs/rook/types"
+)
+
+const historyUsage = "Usage: history <label> | history --limit [N]"
+
+// history handles "history <label>" and "history --limit [N]". Passwords
+// are listed masked; one is printed or copied only when asked for at the
+// prompt.
+func (d *Dashboard) history(arg string) {
+	fields := strings.Fields(arg)
+	if len(fields) > 0 && fields[0] == "--limit" {
+		d.historyLimit(fields[1:])
+		return
+	}
+	if len(fields) == 0 {
+		fmt.Println(historyUsage)
+		return
+	}
+	label := strings.Join(fields, " ")
+
+	data, err := d.storage.GetByLabel(d.user.Username, types.Label(label))
+	if err != nil {
+		fmt.Println("No entry found for label:", label)
+		return
+	}
+	if len(data.History) == 0 {
+		fmt.Println("No earlier passwords saved for", label)
+		return
+	}
+
+	fmt.Printf("[%s]\n  current  %s  set %s\n", label, maskPassword(string(data.Lpassword)), formatWhen(data.Changed))
+	for i, rev := range data.History {
+		fmt.Printf("  %-7d  %s  set %s, replaced %s\n", i+1, maskPassword(string(rev.Password)), formatWhen(rev.Set), formatWhen(rev.Replaced))
+	}
+
+	n := d.pickRevision(data.History)
+	if n == 0 {
+		return
+	}
+
+	// The restored password leaves the history; the current one joins it
+	// when the entry is written, so a restore can itself be undone.
+	rev := data.History[n-1]
+	data.History = append(data.History[:n-1:n-1], data.History[n:]...)
+	data.Lpassword = rev.Password
+	data.Changed = time.Time{}
+	if err := d.storage.AddToStore(d.user.Username, types.Label(label), data); err != nil {
+		fmt.Println("Failed to restore:", err)
+		return
+	}
+	fmt.Printf("Restored revision %d of %s; the previous password is now revision 1.\n", n, label)
+}
+
+// pickRevision asks which revision to restore, revealing or copying
+// revisions on the way as asked. It returns 0 if the user cancels.
+func (d *Dashboard) pickRevision(history []types.Revision) int {
+	for {
+		answer := readLine("Restore which revision? (number; r N reveals it, c N copies it; Enter to cancel): ")
+		if answer == "" {
+			return 0
+		}
+		action, num, found := strings.Cut(answer, " ")
+		if !found {
+			action, num = "", answer
+		}
+		num = strings.TrimSpace(num)
+		n, err := strconv.Atoi(num)
+		if err != nil || n < 1 || n > len(history) {
+			fmt.Println("No such revision:", num)
+			continue
+		}
+		password := string(history[n-1].Password)
+		switch strings.ToLower(action) {
+		case "":
+			return n
+		case "r", "reveal":
+			fmt.Printf("  %-7d  %s\n", n, password)
+		case "c", "copy":
+			if how, err := d.toClipboard(password); err != nil {
+				fmt.Println("Failed to copy:", err)
+			} else {
+				fmt.Printf("Copied revision %d %s.\n", n, how)
+			}
+		default:
+			fmt.Println("Unknown action:", action)
+		}
+	}
+}
+
+// historyLimit shows or sets how many earlier passwords entries keep.
+func (d *Dashboard) historyLimit(args []string) {
+	if len(args) == 0 {
+		fmt.Println("Entries keep up to", d.storage.HistoryLimit(), "earlier passwords.")
+		return
+	}
+	n, err := strconv.Atoi(args[0])
+	if err != nil || n < 0 {
+		fmt.Println(historyUsage)
+		return
+	}
+	limit := n
+	if n == 0 {
+		limit = -1 // zero in the user record means the default
+	}
+	// Update the stored record rather than d.user, which may predate a
+	// rekey.
+	user, err := d.storage.GetUser()
+	if err != nil {
+		fmt.Println("Failed to save setting:", err)
+		return
+	}
+	user.HistoryLimit = limit
+	if err := d.storage.UpdateUser(user); err != nil {
+		fmt.Println("Failed to save setting:", err)
+		return
+	}
+	d.user.HistoryLimit = limit
+	d.storage.SetHistoryLimit(limit)
+	fmt.Println("Entries now keep up to", d.storage.HistoryLimit(), "earlier passwords; longer histories are trimmed on their next change.")
+}
+
+func formatWhen(t time.Time) string {
+	if t.IsZero() {
+		return "(unknown)"
+	}
+	return t.Format("2006-01-02 15:04")
+}
@@ -0,0 +1,159 @@
+package dashboard
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/mbbgs/rook/backup"
+	"github.com/mbbgs/rook/interchange"
+	"github.com/mbbgs/rook/types"
+)
+
+// importFile handles "import <file> [--dry-run] [--on-conflict skip|rename|overwrite]"
+// for CSV exports and KeePass databases.
+func (d *Dashboard) importFile(arg string) {
+	var path []string
+	dryRun := false
+	strategy := interchange.Skip
+
+	fields := strings.Fields(arg)
+	for i := 0; i < len(fields); i++ {
+		switch f := fields[i]; {
+		case f == "--dry-run" || f == "-n":
+			dryRun = true
+		case f == "--on-conflict" && i+1 < len(fields):
+			i++
+			s, err := interchange.ParseCollision(fields[i])
+			if err != nil {
+				fmt.Println(err)
+				return
+			}
+			strategy = s
+		case strings.HasPrefix(f, "--on-conflict="):
+			s, err := interchange.ParseCollision(strings.TrimPrefix(f, "--on-conflict="))
+			if err != nil {
+				fmt.Println(err)
+				return
+			}
+			strategy = s
+		default:
+			path = append(path, f)
+		}
+	}
+	if len(path) == 0 {
+		fmt.Println("Usage: import <file> [--dry-run] [--on-conflict skip|rename|overwrite]")
+		return
+	}
+
+	content, err := os.ReadFile(strings.Join(path, " "))
+	if err != nil {
+		fmt.Println("Failed to open file:", err)
+		return
+	}
+	var format interchange.Format
+	var records []interchange.Record
+	switch {
+	case interchange.IsKDBX(content):
+		format = interchange.KDBX
+		records, err = interchange.ReadKDBX(bytes.NewReader(content), readSecret("KeePass database password: "))
+		if err != nil {
+			fmt.Println("Failed to read KeePass database:", err)
+			return
+		}
+	default:
+		if backup.IsArchive(content) {
+			if content, err = openSealedExport(content); err != nil {
+				fmt.Println("Failed to open sealed export:", err)
+				return
+			}
+		}
+		format, records, err = interchange.ReadCSV(bytes.NewReader(content))
+		if err != nil {
+			fmt.Println("Failed to read CSV:", err)
+			return
+		}
+	}
+
+	current, err := d.storage.GetAllForUser(d.user.Username)
+	if err != nil {
+		fmt.Println("Failed to list data:", err)
+		return
+	}
+	existing := make(map[string]bool, len(current))
+	for label := range current {
+		existing[label] = true
+	}
+
+	actions := interchange.Plan(records, existing, strategy)
+	fmt.Printf("Detected %s export with %d entries (on conflict: %s).\n", format, len(records), strategy)
+
+	if dryRun {
+		for _, a := range actions {
+			printAction(a)
+		}
+		printImportSummary(actions, nil, true)
+		return
+	}
+
+	var failed []interchange.Action
+	for _, a := range actions {
+		if a.Op == interchange.OpSkip {
+			continue
+		}
+		if err := d.storage.AddToStore(d.user.Username, types.Label(a.Label), a.Record.Data()); err != nil {
+			fmt.Printf("  failed    %s: %v\n", a.Label, err)
+			failed = append(failed, a)
+		}
+	}
+	for _, a := range actions {
+		if a.Op == interchange.OpSkip || a.Op == interchange.OpRename {
+			printAction(a)
+		}
+	}
+	printImportSummary(actions, failed, false)
+}
+
+// openSealedExport decrypts a CSV written by "export csv --encrypt".
+func openSealedExport(content []byte) ([]byte, error) {
+	archive, err := backup.Read(bytes.NewReader(content), []byte(readSecret("Export passphrase: ")))
+	if err != nil {
+		return nil, err
+	}
+	if archive.User != nil {
+		return nil, errors.New("this is a vault backup; restore it with -restore")
+	}
+	if !strings.HasSuffix(archive.Name, ".csv") {
+		return nil, fmt.Errorf("%s is not a CSV export", archive.Name)
+	}
+	return archive.Content, nil
+}
+
+func printAction(a interchange.Action) {
+	switch a.Op {
+	case interchange.OpSkip:
+		fmt.Printf("  %-9s %s (%s)\n", a.Op, a.Record.Label, a.Reason)
+	case interchange.OpRename:
+		fmt.Printf("  %-9s %s -> %s\n", a.Op, a.Record.Label, a.Label)
+	default:
+		fmt.Printf("  %-9s %s\n", a.Op, a.Label)
+	}
+}
+
+func printImportSummary(actions, failed []interchange.Action, dryRun bool) {
+	counts := interchange.Summary(actions)
+	verb := "Imported"
+	if dryRun {
+		verb = "Would import"
+	}
+	written := counts[interchange.OpAdd] + counts[interchange.OpRename] + counts[interchange.OpOverwrite] - len(failed)
+	fmt.Printf("%s %d: %d new, %d renamed, %d overwritten; %d skipped",
+		verb, written, counts[interchange.OpAdd], counts[interchange.OpRename],
+		counts[interchange.OpOverwrite], counts[interchange.OpSkip])
+	if len(failed) > 0 {
+		fmt.Printf("; %d failed", len(failed))
+	}
+	fmt.Println(".")
+}
@@ -0,0 +1,50 @@
+package dashboard
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/mbbgs/rook/types"
+)
+
+// splitLabel splits arg into the label of an entry and the words after
+// it, for commands that take more than a label. A label in quotes is taken
+// as written; otherwise the longest run of leading words naming an entry
+// is, so labels with spaces work unquoted. With needRest the label leaves
+// at least one word over. When no run matches, the first word is the
+// label and the caller reports it missing.
+func (d *Dashboard) splitLabel(arg string, needRest bool) (label, rest string, err error) {
+	arg = strings.TrimSpace(arg)
+	if arg == "" {
+		return "", "", nil
+	}
+	if q := arg[0]; q == '"' || q == '\'' {
+		end := strings.IndexByte(arg[1:], q)
+		if end < 0 {
+			return "", "", errors.New("the label has no closing quote")
+		}
+		return arg[1 : end+1], strings.TrimSpace(arg[end+2:]), nil
+	}
+
+	words := strings.Fields(arg)
+	longest := len(words)
+	if needRest {
+		longest--
+	}
+	for n := longest; n > 1; n-- {
+		label := strings.Join(words[:n], " ")
+		if _, err := d.storage.GetByLabel(d.user.Username, types.Label(label)); err == nil {
+			return label, strings.Join(words[n:], " "), nil
+		}
+	}
+	return words[0], strings.Join(words[1:], " "), nil
+}
+
+// unquote strips one pair of matching quotes around s.
+func unquote(s string) string {
+	s = strings.TrimSpace(s)
+	if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0] {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
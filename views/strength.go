@@ -0,0 +1,32 @@
+package dashboard
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mbbgs/rook/strength"
+	"github.com/mbbgs/rook/types"
+)
+
+// entryStrength rates an entry's password, counting its own label, username
+// and site as things an attacker would try first.
+func entryStrength(label string, data types.Data) strength.Result {
+	return strength.Estimate(string(data.Lpassword), label, data.Lname, data.Lurl)
+}
+
+// confirmStrength reports r and, for weak passwords, asks whether to keep
+// going.
+func confirmStrength(r strength.Result) bool {
+	fmt.Println("Strength:", r)
+	if r.Warning != "" {
+		fmt.Println("  Warning:", r.Warning)
+	}
+	for _, s := range r.Suggestions {
+		fmt.Println("  -", s)
+	}
+	if r.Score >= strength.Fair {
+		return true
+	}
+	answer := readLine("This password is easy to guess. Save it anyway? [y/N]: ")
+	return strings.EqualFold(answer, "y") || strings.EqualFold(answer, "yes")
+}
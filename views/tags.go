@@ -0,0 +1,131 @@
+package dashboard
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/mbbgs/rook/types"
+)
+
+// tagList collects --tag flags; each may hold several tags separated by
+// commas.
+type tagList []string
+
+func (t *tagList) String() string { return strings.Join(*t, ",") }
+
+func (t *tagList) Set(s string) error {
+	*t = append(*t, splitList(s)...)
+	return nil
+}
+
+// editEntry loads label, lets edit change it and saves it back.
+func (d *Dashboard) editEntry(label string, edit func(data *types.Data) bool) {
+	data, err := d.storage.GetByLabel(d.user.Username, types.Label(label))
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		fmt.Println("No entry found for label:", label)
+		return
+	}
+	if err != nil {
+		fmt.Println("Failed to get entry:", err)
+		return
+	}
+	if !edit(&data) {
+		return
+	}
+	if err := d.storage.AddToStore(d.user.Username, types.Label(label), data); err != nil {
+		fmt.Println("Failed to save entry:", err)
+	}
+}
+
+// tagEntry handles "tag <label> <tag...>". Tags may also be separated by
+// commas; ones the entry already has, in any case, are skipped.
+func (d *Dashboard) tagEntry(arg string) {
+	label, rest, err := d.splitLabel(arg, true)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	tags := splitList(strings.Join(strings.Fields(rest), ","))
+	if label == "" || len(tags) == 0 {
+		fmt.Println("Usage: tag <label> <tag...>")
+		return
+	}
+	d.editEntry(label, func(data *types.Data) bool {
+		var added []string
+		for _, tag := range tags {
+			if !data.HasTag(tag) {
+				data.Tags = append(data.Tags, tag)
+				added = append(added, tag)
+			}
+		}
+		if len(added) == 0 {
+			fmt.Printf("[%s] already has those tags.\n", label)
+			return false
+		}
+		fmt.Printf("Tagged [%s]: %s\n", label, strings.Join(data.Tags, ", "))
+		return true
+	})
+}
+
+// untagEntry handles "untag <label> <tag...>".
+func (d *Dashboard) untagEntry(arg string) {
+	label, rest, err := d.splitLabel(arg, true)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	drop := types.Data{Tags: splitList(strings.Join(strings.Fields(rest), ","))}
+	if label == "" || len(drop.Tags) == 0 {
+		fmt.Println("Usage: untag <label> <tag...>")
+		return
+	}
+	d.editEntry(label, func(data *types.Data) bool {
+		var kept []string
+		for _, tag := range data.Tags {
+			if !drop.HasTag(tag) {
+				kept = append(kept, tag)
+			}
+		}
+		if len(kept) == len(data.Tags) {
+			fmt.Printf("[%s] has none of those tags.\n", label)
+			return false
+		}
+		data.Tags = kept
+		if len(kept) == 0 {
+			fmt.Printf("Removed all tags from [%s].\n", label)
+		} else {
+			fmt.Printf("Tagged [%s]: %s\n", label, strings.Join(kept, ", "))
+		}
+		return true
+	})
+}
+
+// moveEntry handles "mv <label> <folder>"; a folder of "/" moves the entry
+// out of every folder.
+func (d *Dashboard) moveEntry(arg string) {
+	label, rest, err := d.splitLabel(arg, true)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if label == "" || rest == "" {
+		fmt.Println("Usage: mv <label> <folder>   (mv <label> / for no folder)")
+		return
+	}
+	folder := types.CleanFolder(unquote(rest))
+	d.editEntry(label, func(data *types.Data) bool {
+		if data.Folder == folder {
+			fmt.Printf("[%s] is already there.\n", label)
+			return false
+		}
+		data.Folder = folder
+		if folder == "" {
+			fmt.Printf("Moved [%s] out of its folder.\n", label)
+		} else {
+			fmt.Printf("Moved [%s] to %s.\n", label, folder)
+		}
+		return true
+	})
+}
@@ -0,0 +1,143 @@
+package dashboard
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/mbbgs/rook/store"
+	"github.com/mbbgs/rook/types"
+)
+
+const trashUsage = "Usage: trash | trash --retention [days]"
+
+// retention is how long trashed entries are kept; zero means forever.
+func (d *Dashboard) retention() time.Duration {
+	switch days := d.user.TrashRetentionDays; {
+	case days < 0:
+		return 0
+	case days == 0:
+		return store.DefaultTrashRetention
+	default:
+		return time.Duration(days) * 24 * time.Hour
+	}
+}
+
+// purgeTrash drops entries that have outlived the retention period.
+func (d *Dashboard) purgeTrash() {
+	keep := d.retention()
+	if keep == 0 {
+		return
+	}
+	n, err := d.storage.PurgeTrash(d.user.Username, time.Now().Add(-keep))
+	if err != nil {
+		fmt.Println("Failed to purge trash:", err)
+		return
+	}
+	if n > 0 {
+		fmt.Printf("Purged %d entries that were in the trash for over %d days.\n", n, int(keep.Hours()/24))
+	}
+}
+
+// listTrash handles "trash" and "trash --retention [days]".
+func (d *Dashboard) listTrash(arg string) {
+	fields := strings.Fields(arg)
+	if len(fields) > 0 {
+		if fields[0] != "--retention" {
+			fmt.Println(trashUsage)
+			return
+		}
+		d.trashRetention(fields[1:])
+		return
+	}
+
+	trashed, err := d.storage.GetTrash(d.user.Username)
+	if err != nil {
+		fmt.Println("Failed to list trash:", err)
+		return
+	}
+	if len(trashed) == 0 {
+		fmt.Println("The trash is empty.")
+		return
+	}
+	keep := d.retention()
+	for _, t := range trashed {
+		purge := "kept until emptied"
+		if keep > 0 {
+			purge = "purged after " + t.Deleted.Add(keep).Format("2006-01-02")
+		}
+		fmt.Printf("[%s]\n  User: %s\n  Deleted: %s (%s)\n\n", t.Label, t.Data.Lname, t.Deleted.Format(time.RFC1123), purge)
+	}
+}
+
+func (d *Dashboard) trashRetention(args []string) {
+	if len(args) == 0 {
+		if keep := d.retention(); keep > 0 {
+			fmt.Printf("Trashed entries are purged after %d days.\n", int(keep.Hours()/24))
+		} else {
+			fmt.Println("Trashed entries are kept until the trash is emptied.")
+		}
+		return
+	}
+	days, err := strconv.Atoi(args[0])
+	if err != nil || days < 0 {
+		fmt.Println(trashUsage)
+		return
+	}
+	if days == 0 {
+		days = -1 // zero in the user record means the default
+	}
+	user, err := d.storage.GetUser()
+	if err != nil {
+		fmt.Println("Failed to save setting:", err)
+		return
+	}
+	user.TrashRetentionDays = days
+	if err := d.storage.UpdateUser(user); err != nil {
+		fmt.Println("Failed to save setting:", err)
+		return
+	}
+	d.user.TrashRetentionDays = days
+	d.listTrash("--retention")
+	d.purgeTrash()
+}
+
+func (d *Dashboard) restoreFromTrash(label string) {
+	err := d.storage.RestoreFromTrash(d.user.Username, types.Label(label))
+	switch {
+	case errors.Is(err, store.ErrLabelTaken):
+		fmt.Println("An entry named", label, "already exists; remove it first.")
+	case errors.Is(err, badger.ErrKeyNotFound):
+		fmt.Println("No entry in the trash for label:", label)
+	case err != nil:
+		fmt.Println("Failed to restore:", err)
+	default:
+		fmt.Println("Entry restored.")
+	}
+}
+
+func (d *Dashboard) emptyTrash() {
+	trashed, err := d.storage.GetTrash(d.user.Username)
+	if err != nil {
+		fmt.Println("Failed to list trash:", err)
+		return
+	}
+	if len(trashed) == 0 {
+		fmt.Println("The trash is empty.")
+		return
+	}
+	answer := readLine(fmt.Sprintf("Permanently delete %d entries from the trash? [y/N]: ", len(trashed)))
+	if !strings.EqualFold(answer, "y") {
+		fmt.Println("Trash kept.")
+		return
+	}
+	n, err := d.storage.PurgeTrash(d.user.Username, time.Time{})
+	if err != nil {
+		fmt.Println("Failed to empty trash:", err)
+		return
+	}
+	fmt.Printf("Deleted %d entries.\n", n)
+}
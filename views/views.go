@@ -3,15 +3,22 @@ package dashboard
 import (
     "bufio"
     "encoding/json"
+    "flag"
     "fmt"
+    "io"
     "time"
     "strings"
     "os"
     "path/filepath"
+    "sort"
     
+    "github.com/mbbgs/rook/breach"
+    "github.com/mbbgs/rook/clipboard"
     "github.com/mbbgs/rook/consts"
+    "github.com/mbbgs/rook/events"
     "github.com/mbbgs/rook/models"
     "github.com/mbbgs/rook/store"
+    "github.com/mbbgs/rook/terms"
     "github.com/mbbgs/rook/types"
     "github.com/mbbgs/rook/utils"
 )
@@ -19,19 +26,29 @@ import (
 type Dashboard struct {
     storage *store.Store
     user  *models.User
+    event *events.Event
+
+    pwned       *breach.Dataset
+    pwnedOpened bool
+
+    clip *clipboard.Clipboard
 }
 
-func NewDashboard(storee any, user any) *Dashboard {
+func NewDashboard(storee any, user any, event *events.Event) *Dashboard {
     s, ok1 := storee.(*store.Store)
     u, ok2 := user.(*models.User)
     if !ok1 || !ok2 {
         panic("Invalid types passed to NewDashboard")
     }
-    return &Dashboard{storage: s, user: u}
+    s.SetHistoryLimit(u.HistoryLimit)
+    return &Dashboard{storage: s, user: u, event: event}
 }
 func (d *Dashboard) Start() {
+    defer d.close()
     scanner := bufio.NewScanner(os.Stdin)
     cmdList()
+    d.warnBreached()
+    d.purgeTrash()
     for {
         fmt.Print("dashboard> ")
         if !scanner.Scan() {
@@ -51,23 +68,65 @@ func (d *Dashboard) Start() {
 
         switch cmd {
         case "list":
-            d.listData()
+            d.listData(arg)
         case "add":
-            d.addData()
+            d.addData(arg)
+        case "tag":
+            d.tagEntry(arg)
+        case "untag":
+            d.untagEntry(arg)
+        case "mv":
+            d.moveEntry(arg)
+        case "find":
+            if arg == "" {
+                fmt.Println("Usage: find <query>")
+                continue
+            }
+            d.find(arg)
         case "get":
             if arg == "" {
                 fmt.Println("Usage: get <label>")
                 continue
             }
             d.getByLabel(arg)
+        case "copy":
+            d.copyField(arg)
         case "remove":
             if arg == "" {
                 fmt.Println("Usage: remove <label>")
                 continue
             }
             d.removeByLabel(arg)
+        case "trash":
+            d.listTrash(arg)
+        case "restore":
+            if arg == "" {
+                fmt.Println("Usage: restore <label>")
+                continue
+            }
+            d.restoreFromTrash(arg)
+        case "empty-trash":
+            d.emptyTrash()
+        case "import":
+            if arg == "" {
+                fmt.Println("Usage: import <file> [--dry-run] [--on-conflict skip|rename|overwrite]")
+                continue
+            }
+            d.importFile(arg)
+        case "export":
+            d.exportData(arg)
+        case "history":
+            d.history(arg)
+        case "generate":
+            d.generate(arg)
+        case "health", "audit":
+            d.healthReport(arg)
+        case "rekey":
+            d.event.Emit(consts.REKEY_VAULT, d.storage, d.user)
         case "wipe":
-            d.wipeStore()
+            if d.wipeStore() {
+                return
+            }
         case "help":
             d.printHelp()
         case "user":
@@ -86,12 +145,61 @@ func (d *Dashboard) Start() {
     }
 }
 
+// close clears anything still on the clipboard and drops the session key
+// before releasing the store.
+func (d *Dashboard) close() {
+    if d.clip != nil && d.clip.Pending() {
+        fmt.Println("Clearing the clipboard.")
+        _ = d.clip.Clear()
+    }
+    if d.pwned != nil {
+        _ = d.pwned.Close()
+    }
+    if d.storage != nil {
+        d.storage.Lock()
+        _ = d.storage.Close()
+    }
+}
+
 func (d *Dashboard) printHelp() {
     fmt.Println(`Commands:
-  list              - List all entries for current user
-  add               - Add new entry
-  get <label>       - Show entry by label
-  remove <label>    - Remove entry by label
+  list [--kind K]   - List all entries for current user, or one kind
+                      [--tag T]... [--folder F] lists entries with every
+                      tag given, in folder F or below it
+  add [kind]        - Add new entry: login (default), note, card, ssh,
+                      token, database or identity
+  get <label>       - Show entry by label, with secrets masked
+  copy <label> [--field F]
+                    - Copy the password, or user, url, totp, notes or a
+                      field, to the clipboard; it is cleared after 30s
+                      (copy --timeout [seconds] shows or changes that)
+  find <query>      - Fuzzy search labels, usernames, URLs, tags and notes
+  tag <label> <tag...>
+                    - Add tags to an entry; quote a label with spaces
+                      if it could run into the tags: tag "My Bank" x
+  untag <label> <tag...>
+                    - Remove tags from an entry
+  mv <label> <folder>
+                    - Move an entry to a folder, e.g. infra/aws; / for none
+  remove <label>    - Move entry to the trash
+  trash             - List entries in the trash
+                      [--retention [days]] shows or sets when they are purged
+  restore <label>   - Move an entry back from the trash
+  empty-trash       - Permanently delete everything in the trash
+  history <label>   - Show earlier passwords; reveal, copy or restore one
+  history --limit [N]
+                    - Show or set how many earlier passwords to keep
+  import <file>     - Import a browser or password manager CSV export,
+                      or a KeePass KDBX 4 database
+                      [--dry-run] [--on-conflict skip|rename|overwrite]
+  export <fmt> <file>
+                    - Export all entries as bitwarden, csv, keepass (XML)
+                      or kdbx; [--encrypt] seals plaintext formats
+  generate [opts]   - Generate a password or diceware passphrase and copy
+                      it; --show prints it (generate --help for options)
+  health [--days N] - Audit for reused, similar, weak and old passwords
+                      and missing URLs (alias: audit)
+  rekey             - Rotate the vault encryption key
   wipe              - Wipe entire store (all users)
   help              - Show this help
   user              - get current user
@@ -100,53 +208,120 @@ func (d *Dashboard) printHelp() {
   exit, quit        - Exit dashboard`)
 }
 
-func (d *Dashboard) listData() {
+func (d *Dashboard) listData(arg string) {
+    fs := flag.NewFlagSet("list", flag.ContinueOnError)
+    fs.SetOutput(io.Discard)
+    kindName := fs.String("kind", "", "")
+    folder := fs.String("folder", "", "")
+    var tags tagList
+    fs.Var(&tags, "tag", "")
+    if err := fs.Parse(strings.Fields(arg)); err != nil || fs.NArg() > 0 {
+        fmt.Println("Usage: list [--kind login|note|card|ssh|token|database|identity] [--tag T]... [--folder F]")
+        return
+    }
+    var only types.Kind
+    if *kindName != "" {
+        kind, err := types.ParseKind(*kindName)
+        if err != nil {
+            fmt.Println(err)
+            return
+        }
+        only = kind
+    }
+
     found := false
-    allData, err := d.storage.GetAllForUser(d.user.Username)
+    filter := store.Filter{Tags: tags, Folder: *folder}
+    allData, err := d.storage.Filtered(d.user.Username, filter)
     if err != nil {
         fmt.Println("Failed to list data:", err)
         return
     }
 
-    for label, data := range allData {
-        maskedPwd := maskPassword(string(data.Lpassword))
-        fmt.Printf("[%s]\n  URL: %s\n  User: %s\n  Password: %s\n  Last Access: %s\n\n",
-            label, data.Lurl, data.Lname, maskedPwd, data.LastAccess.Format(time.RFC1123))
+    // Entries are listed folder by folder, by label within each.
+    labels := make([]string, 0, len(allData))
+    for label := range allData {
+        labels = append(labels, label)
+    }
+    sort.Slice(labels, func(i, j int) bool {
+        fi, fj := strings.ToLower(allData[labels[i]].Folder), strings.ToLower(allData[labels[j]].Folder)
+        if fi != fj {
+            return fi < fj
+        }
+        return strings.ToLower(labels[i]) < strings.ToLower(labels[j])
+    })
+
+    for _, label := range labels {
+        data := allData[label]
+        kind := data.EntryKind()
+        if only != "" && kind != only {
+            continue
+        }
+        if kind.HasPassword() {
+            maskedPwd := maskPassword(string(data.Lpassword))
+            fmt.Printf("[%s]\n  URL: %s\n  User: %s\n  Password: %s\n  Strength: %s\n  Last Access: %s\n",
+                label, data.Lurl, data.Lname, maskedPwd, entryStrength(label, data), data.LastAccess.Format(time.RFC1123))
+        } else {
+            fmt.Printf("[%s]\n  Last Access: %s\n", label, data.LastAccess.Format(time.RFC1123))
+        }
+        if kind != types.KindLogin {
+            fmt.Printf("  Kind: %s\n", kind.Title())
+        }
+        if n := d.entryBreached(data); n > 0 {
+            fmt.Printf("  Breached: %s\n", breachNote(n))
+        }
+        for _, line := range entrySummary(data) {
+            fmt.Println(" ", line)
+        }
+        fmt.Println()
         found = true
     }
 
-    if !found {
+    if !found && (only != "" || len(tags) > 0 || *folder != "") {
+        fmt.Println("No entries match the filter for user", d.user.Username)
+    } else if !found {
         fmt.Println("No saved entries for user", d.user.Username)
     }
 }
 
-func (d *Dashboard) addData() {
-    
+func (d *Dashboard) addData(arg string) {
+	kind, err := types.ParseKind(arg)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
 	var label, lname, lpassword, lurl string
 
-	fmt.Print("Enter Label: ")
-	fmt.Scanln(&label)
+	label = readLine("Enter Label: ")
 	if label == "" {
 		fmt.Println("Label cannot be empty.")
 		return
 	}
 
-	fmt.Print("Enter Username/Email: ")
-	fmt.Scanln(&lname)
+	if !kind.HasPassword() {
+		d.addKind(label, kind)
+		return
+	}
+
+	lname = readLine("Enter Username/Email: ")
 	if lname == "" {
 		fmt.Println("Username/Email cannot be empty.")
 		return
 	}
 
-	fmt.Print("Enter Password: ")
+	fmt.Print("Enter Password (leave empty to generate one): ")
 	fmt.Scanln(&lpassword)
+	if lpassword == "" {
+		lpassword = d.promptGenerated()
+	}
 	if lpassword == "" {
 		fmt.Println("Password cannot be empty.")
 		return
 	}
 
-	fmt.Print("Enter URL (optional): ")
-	fmt.Scanln(&lurl)
+	if kind == types.KindLogin {
+		lurl = readLine("Enter URL (optional): ")
+	}
 	if lurl == "" {
 		lurl = "(Not Set)"
 	}
@@ -156,8 +331,24 @@ func (d *Dashboard) addData() {
 		Lpassword: []byte(lpassword),
 		Lurl:      lurl,
 	}
+	if kind != types.KindLogin {
+		data.Kind = kind
+	}
+	promptKindFields(&data)
+	promptDetails(&data)
+	if n := d.entryBreached(data); n > 0 {
+		fmt.Println("This password was", breachNote(n)+".")
+		if !strings.EqualFold(readLine("Save it anyway? [y/N]: "), "y") {
+			fmt.Println("Entry not saved.")
+			return
+		}
+	}
+	if !confirmStrength(entryStrength(label, data)) {
+		fmt.Println("Entry not saved.")
+		return
+	}
 
-	err := d.storage.AddToStore(d.user.Username, types.Label(label), data)
+	err = d.storage.AddToStore(d.user.Username, types.Label(label), data)
 	if err != nil {
 		fmt.Println("Failed to add data:", err)
 	} else {
@@ -181,17 +372,28 @@ func (d *Dashboard) getByLabel(label string) {
         fmt.Println("No entry found for label:", label)
         return
     }
-    pretty, _ := json.MarshalIndent(data, "", "  ")
-    fmt.Println(string(pretty))
+    showEntry(label, data)
+    if err := d.storage.Touch(d.user.Username, types.Label(label)); err != nil {
+        fmt.Println("Failed to record access:", err)
+    }
 }
 
 func (d *Dashboard) removeByLabel(label string) {
-    err := d.storage.RemoveFromStore(d.user.Username, types.Label(label))
+    if _, err := d.storage.GetByLabel(d.user.Username, types.Label(label)); err != nil {
+        fmt.Println("No entry found for label:", label)
+        return
+    }
+    answer := readLine(fmt.Sprintf("Move %s to the trash? [y/N]: ", label))
+    if !strings.EqualFold(answer, "y") {
+        fmt.Println("Entry kept.")
+        return
+    }
+    err := d.storage.Trash(d.user.Username, types.Label(label))
     if err != nil {
         fmt.Println("Failed to remove:", err)
         return
     }
-    fmt.Println("Entry removed successfully.")
+    fmt.Println("Entry moved to the trash; use restore", label, "to bring it back.")
 }
 
 func maskPassword(pwd string) string {
@@ -202,35 +404,38 @@ func maskPassword(pwd string) string {
 }
 
 
-func (d *Dashboard) wipeStore() {
+// wipeStore deletes the store with every account in it. The session ends
+// with it, as there is no user left to be logged in as; it reports whether
+// the store is gone.
+func (d *Dashboard) wipeStore() bool {
 	dir, err := utils.GetSessionDir()
 	if err != nil {
 		utils.ErrorE(err)
-		return
+		return false
 	}
 
 	path := filepath.Join(dir, consts.STORE_FILE_PATH)
 
 	// Close DB if open
 	if d.storage != nil {
+		d.storage.Lock()
 		_ = d.storage.Close()
+		d.storage = nil
 	}
 
 	// Remove BadgerDB directory
 	if err := os.RemoveAll(path); err != nil {
 		utils.Error("Failed to wipe store: " + err.Error())
-		return
+		return true
 	}
-
-	// Reinitialize empty store
-	newStore, err := store.NewStore()
-	if err != nil {
-		utils.Error("Failed to reinitialize store: " + err.Error())
-		return
+	for _, snapshot := range terms.SchemaSnapshots() {
+		if err := terms.ShredAndDelete(snapshot, 3); err != nil {
+			utils.Error("Failed to wipe " + snapshot + ": " + err.Error())
+		}
 	}
-	d.storage = newStore
 
-	utils.Done("Store wiped successfully.")
+	utils.Done("Store wiped successfully. You have been logged out; run rook again to register.")
+	return true
 }
 
 